@@ -0,0 +1,88 @@
+package loyalty
+
+import (
+	"fmt"
+	"time"
+)
+
+// AccountState is where a customer's account is in its lifecycle.
+type AccountState string
+
+const (
+	AccountStateActive AccountState = "active"
+	// AccountStateSuspended accounts, e.g. under fraud review, keep their points and status but earn nothing and
+	// can't redeem until they're reactivated.
+	AccountStateSuspended AccountState = "suspended"
+	// AccountStateClosedPending accounts have been canceled, but can be reactivated with everything intact until
+	// AccountStatus.ClosesAt.
+	AccountStateClosedPending AccountState = "closedPending"
+	// AccountStateClosed is final; the customer's workflow completes.
+	AccountStateClosed AccountState = "closed"
+)
+
+// accountTransitions lists the states each state can move to.
+var accountTransitions = map[AccountState][]AccountState{
+	AccountStateActive:        {AccountStateSuspended, AccountStateClosedPending, AccountStateClosed},
+	AccountStateSuspended:     {AccountStateActive, AccountStateClosedPending, AccountStateClosed},
+	AccountStateClosedPending: {AccountStateActive, AccountStateClosed},
+}
+
+// AccountStatus is the account's state, why it's in it and since when.
+type AccountStatus struct {
+	State  AccountState
+	Reason string
+	Since  time.Time
+	// ClosesAt is when a closed-pending account closes for good.
+	ClosesAt time.Time
+}
+
+// AccountChangeRequest is the payload of SignalSuspendAccount and SignalReactivateAccount.
+type AccountChangeRequest struct {
+	Reason string
+}
+
+// CancelAccountRequest is the optional payload of SignalCancelAccount.
+type CancelAccountRequest struct {
+	Reason string
+	// RevokeGuests also ends the sponsored status of every guest this customer invited. With a grace period, that
+	// waits until the account actually closes.
+	RevokeGuests bool
+}
+
+// setAccountState moves the account to state. AccountActive is kept in step for callers that predate account
+// states: it's only true while the account is active.
+func (c *CustomerInfo) setAccountState(state AccountState, reason string, now time.Time) {
+	c.Account = AccountStatus{State: state, Reason: reason, Since: now}
+	c.AccountActive = state == AccountStateActive
+}
+
+// accountOpen reports whether the account hasn't closed yet, so the workflow keeps running.
+func (c *CustomerInfo) accountOpen() bool {
+	return c.Account.State != AccountStateClosed
+}
+
+// checkActive returns an error unless the account is active.
+func (c *CustomerInfo) checkActive() error {
+	if c.Account.State != AccountStateActive {
+		return fmt.Errorf("account '%v' is not active", c.CustomerID)
+	}
+	return nil
+}
+
+// checkPointsChange returns an error unless the account can take the change. Suspended and closing accounts don't
+// earn, but can still have points taken back.
+func (c *CustomerInfo) checkPointsChange(earning bool) error {
+	if earning || !c.accountOpen() {
+		return c.checkActive()
+	}
+	return nil
+}
+
+func (c *CustomerInfo) validateTransition(state AccountState) error {
+	for _, next := range accountTransitions[c.Account.State] {
+		if next == state {
+			return nil
+		}
+	}
+	return fmt.Errorf("account '%v' can't go from '%v' to '%v'", c.CustomerID, c.Account.State, state)
+}
@@ -0,0 +1,239 @@
+package loyalty
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *UnitTestSuite) Test_SuspendAndReactivateAccount() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	suspended := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateSuspendAccount, suspended, AccountChangeRequest{Reason: "fraud review"})
+	}, time.Second*1)
+
+	// suspended accounts earn nothing and can't redeem, but can have points taken back
+	redeemed := &updateCallbacks{}
+	earned := &updateCallbacks{}
+	clawedBack := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-1", Amount: 100})
+		env.UpdateWorkflow(UpdateRedeemReward, redeemed, RedeemRequest{ID: "redeem-1", RewardID: "coffee"})
+		env.UpdateWorkflow(UpdateAddPoints, earned, PointsTransaction{ID: "txn-3", Amount: 100})
+		env.UpdateWorkflow(UpdateAddPoints, clawedBack, PointsTransaction{ID: "clawback-1", Amount: -50})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var status GetStatusResponse
+		s.NoError(result.Get(&status))
+		s.Equal(AccountStateSuspended, status.Account.State)
+		s.Equal("fraud review", status.Account.Reason)
+		s.False(status.AccountActive)
+		s.Equal(450, status.Points)
+	}, time.Second*3)
+
+	reactivated := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateReactivateAccount, reactivated, AccountChangeRequest{Reason: "review cleared"})
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-2", Amount: 100})
+	}, time.Second*4)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var status GetStatusResponse
+		s.NoError(result.Get(&status))
+		s.Equal(AccountStateActive, status.Account.State)
+		s.True(status.AccountActive)
+		s.Equal(550, status.Points)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*5)
+
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		LoyaltyPoints: 500,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.True(suspended.accepted)
+	s.Equal(AccountStateSuspended, suspended.result.(GetStatusResponse).Account.State)
+	s.ErrorContains(redeemed.rejected, "is not active")
+	s.ErrorContains(earned.rejected, "is not active")
+	s.NoError(clawedBack.rejected)
+	s.NoError(clawedBack.err)
+	s.True(reactivated.accepted)
+	s.NoError(reactivated.err)
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(accountSuspendedEmail()))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(accountReactivatedEmail()))
+}
+
+func (s *UnitTestSuite) Test_CancellationGracePeriod() {
+	env := s.NewTestWorkflowEnvironment()
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, CancelAccountRequest{Reason: "moving away", RevokeGuests: true})
+	}, time.Hour*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var status GetStatusResponse
+		s.NoError(result.Get(&status))
+		s.Equal(AccountStatus{
+			State:    AccountStateClosedPending,
+			Reason:   "moving away",
+			Since:    start.Add(time.Hour * 1),
+			ClosesAt: start.Add(time.Hour * 25),
+		}, status.Account)
+
+		// changed their mind, with everything intact
+		env.SignalWorkflow(SignalReactivateAccount, AccountChangeRequest{})
+	}, time.Hour*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var status GetStatusResponse
+		s.NoError(result.Get(&status))
+		s.Equal(AccountStateActive, status.Account.State)
+
+		result, err = env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+		var guests []string
+		s.NoError(result.Get(&guests))
+		s.Equal([]string{"guest"}, guests)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*26)
+
+	program := DefaultProgram()
+	program.CancellationGracePeriod = time.Hour * 24
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+		Guests:        []string{"guest"},
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the first timer fired after reactivation without closing anything; the second closed the account
+	s.Equal(start.Add(time.Hour*50), env.Now().UTC())
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything,
+		notified(accountClosingEmail(start.Add(time.Hour*25))))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything,
+		notified(accountClosingEmail(start.Add(time.Hour*50))))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(cancelAccountEmail()))
+}
+
+func (s *UnitTestSuite) Test_AccountTransitionsValidated() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	reactivateActive := &updateCallbacks{}
+	cancel := &updateCallbacks{}
+	suspendClosing := &updateCallbacks{}
+	cancelClosing := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateReactivateAccount, reactivateActive, AccountChangeRequest{})
+		env.UpdateWorkflow(UpdateCancelAccount, cancel, CancelAccountRequest{})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateSuspendAccount, suspendClosing, AccountChangeRequest{})
+		env.UpdateWorkflow(UpdateCancelAccount, cancelClosing, CancelAccountRequest{})
+	}, time.Second*2)
+
+	program := DefaultProgram()
+	program.CancellationGracePeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(reactivateActive.rejected, "can't go from 'active' to 'active'")
+	s.True(cancel.accepted)
+	s.Equal(AccountStateClosedPending, cancel.result.(GetStatusResponse).Account.State)
+	s.ErrorContains(suspendClosing.rejected, "can't go from 'closedPending' to 'suspended'")
+	s.ErrorContains(cancelClosing.rejected, "can't go from 'closedPending' to 'closedPending'")
+}
+
+func (s *UnitTestSuite) Test_InviteGuestRequiresActiveAccount() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	queryGuests := func() []string {
+		result, err := env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+		var guests []string
+		s.NoError(result.Get(&guests))
+		return guests
+	}
+
+	suspended := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalSuspendAccount, AccountChangeRequest{Reason: "fraud review"})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+		env.UpdateWorkflow(UpdateInviteGuest, suspended, "guest")
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		s.Empty(queryGuests())
+		env.SignalWorkflow(SignalReactivateAccount, AccountChangeRequest{})
+		env.SignalWorkflow(SignalCancelAccount, CancelAccountRequest{Reason: "moving away"})
+	}, time.Second*3)
+
+	closing := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+		env.UpdateWorkflow(UpdateInviteGuest, closing, "guest")
+	}, time.Second*4)
+	env.RegisterDelayedCallback(func() {
+		s.Empty(queryGuests())
+	}, time.Second*5)
+
+	program := DefaultProgram()
+	program.CancellationGracePeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[3].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(suspended.rejected, "account 'host' is not active")
+	s.ErrorContains(closing.rejected, "account 'host' is not active")
+	env.AssertNotCalled(s.T(), "StartGuestWorkflow", mock.Anything, mock.Anything)
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(insufficientPointsEmail()))
+}
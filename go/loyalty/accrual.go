@@ -0,0 +1,152 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Purchase is a spend reported by an upstream system, which the accrual engine converts into points.
+type Purchase struct {
+	ID        string
+	Amount    float64
+	Currency  string
+	Category  string
+	Timestamp time.Time
+	// RefundOf is the ID of the purchase this one refunds, if any. Refunds have a negative amount.
+	RefundOf string
+}
+
+// validateRefund checks that refunds, and only refunds, have a negative amount.
+func (p Purchase) validateRefund() error {
+	if p.RefundOf != "" && p.Amount > 0 {
+		return fmt.Errorf("refund '%v' has a positive amount", p.ID)
+	}
+	if p.RefundOf == "" && p.Amount < 0 {
+		return fmt.Errorf("purchase '%v' has a negative amount but isn't a refund", p.ID)
+	}
+	return nil
+}
+
+// Accrual is the working state passed through an AccrualEngine's rules.
+type Accrual struct {
+	// Purchase may be rewritten by rules, e.g. converted into the base currency.
+	Purchase Purchase
+	Points   float64
+}
+
+// AccrualRule is one step in converting a purchase into points. Rules run in order, each seeing the previous rule's
+// result.
+type AccrualRule interface {
+	Apply(accrual *Accrual) error
+}
+
+// AccrualRuleFunc adapts a function to an AccrualRule.
+type AccrualRuleFunc func(accrual *Accrual) error
+
+func (f AccrualRuleFunc) Apply(accrual *Accrual) error {
+	return f(accrual)
+}
+
+// CurrencyConversion converts the purchase amount into BaseCurrency. Rates are units of BaseCurrency per unit of each
+// currency.
+type CurrencyConversion struct {
+	BaseCurrency string
+	Rates        map[string]float64
+}
+
+func (c CurrencyConversion) Apply(accrual *Accrual) error {
+	if accrual.Purchase.Currency == c.BaseCurrency {
+		return nil
+	}
+	rate, ok := c.Rates[accrual.Purchase.Currency]
+	if !ok {
+		return fmt.Errorf("no conversion rate from '%v' to '%v'", accrual.Purchase.Currency, c.BaseCurrency)
+	}
+	accrual.Purchase.Amount *= rate
+	accrual.Purchase.Currency = c.BaseCurrency
+	return nil
+}
+
+// BaseRate earns PointsPerUnit points for each unit of currency spent.
+type BaseRate struct {
+	PointsPerUnit float64
+}
+
+func (b BaseRate) Apply(accrual *Accrual) error {
+	accrual.Points += accrual.Purchase.Amount * b.PointsPerUnit
+	return nil
+}
+
+// CategoryBonus multiplies the points earned so far by the purchase category's multiplier, if it has one.
+type CategoryBonus struct {
+	Multipliers map[string]float64
+}
+
+func (c CategoryBonus) Apply(accrual *Accrual) error {
+	if multiplier, ok := c.Multipliers[accrual.Purchase.Category]; ok {
+		accrual.Points *= multiplier
+	}
+	return nil
+}
+
+type RoundingMode int
+
+const (
+	RoundDown RoundingMode = iota
+	RoundNearest
+	RoundUp
+)
+
+func (r RoundingMode) round(points float64) int {
+	switch r {
+	case RoundNearest:
+		return int(math.Round(points))
+	case RoundUp:
+		return int(math.Ceil(points))
+	default:
+		return int(math.Floor(points))
+	}
+}
+
+// AccrualEngine converts purchases into points by running its rules in order, then rounding.
+type AccrualEngine struct {
+	Rules    []AccrualRule
+	Rounding RoundingMode
+}
+
+// DefaultAccrualEngine earns one point per US dollar, rounded down.
+func DefaultAccrualEngine() *AccrualEngine {
+	return &AccrualEngine{
+		Rules: []AccrualRule{
+			CurrencyConversion{BaseCurrency: "USD"},
+			BaseRate{PointsPerUnit: 1},
+		},
+		Rounding: RoundDown,
+	}
+}
+
+// Calculate returns the points the purchase earns.
+func (e *AccrualEngine) Calculate(purchase Purchase) (int, error) {
+	if purchase.Currency == "" {
+		return 0, errors.New("purchase has no currency")
+	}
+	if math.IsNaN(purchase.Amount) || math.IsInf(purchase.Amount, 0) {
+		return 0, fmt.Errorf("purchase amount %v is not a number", purchase.Amount)
+	}
+
+	accrual := Accrual{Purchase: purchase}
+	for _, rule := range e.Rules {
+		err := rule.Apply(&accrual)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// round refunds toward zero the same way as purchases
+	if accrual.Points < 0 {
+		return -e.Rounding.round(-accrual.Points), nil
+	}
+	return e.Rounding.round(accrual.Points), nil
+}
@@ -0,0 +1,93 @@
+package loyalty
+
+import (
+	"errors"
+)
+
+func (s *UnitTestSuite) Test_AccrualEngineDefault() {
+	engine := DefaultAccrualEngine()
+
+	points, err := engine.Calculate(Purchase{ID: "p1", Amount: 42.99, Currency: "USD"})
+	s.NoError(err)
+	s.Equal(42, points)
+
+	points, err = engine.Calculate(Purchase{ID: "p2", Amount: -42.99, Currency: "USD"})
+	s.NoError(err)
+	s.Equal(-42, points)
+
+	_, err = engine.Calculate(Purchase{ID: "p3", Amount: 10, Currency: "EUR"})
+	s.ErrorContains(err, "no conversion rate from 'EUR'")
+
+	_, err = engine.Calculate(Purchase{ID: "p4", Amount: 10})
+	s.Error(err)
+}
+
+func (s *UnitTestSuite) Test_AccrualEngineRules() {
+	engine := &AccrualEngine{
+		Rules: []AccrualRule{
+			CurrencyConversion{BaseCurrency: "USD", Rates: map[string]float64{"EUR": 1.1}},
+			BaseRate{PointsPerUnit: 2},
+			CategoryBonus{Multipliers: map[string]float64{"dining": 3}},
+		},
+		Rounding: RoundNearest,
+	}
+
+	// 10 EUR -> 11 USD -> 22 points -> 66 points for dining
+	points, err := engine.Calculate(Purchase{Amount: 10, Currency: "EUR", Category: "dining"})
+	s.NoError(err)
+	s.Equal(66, points)
+
+	points, err = engine.Calculate(Purchase{Amount: 10.3, Currency: "USD", Category: "groceries"})
+	s.NoError(err)
+	s.Equal(21, points)
+
+	engine.Rounding = RoundUp
+	points, err = engine.Calculate(Purchase{Amount: 10.1, Currency: "USD"})
+	s.NoError(err)
+	s.Equal(21, points)
+}
+
+func (s *UnitTestSuite) Test_AccrualEngineCustomRule() {
+	errBlocked := errors.New("blocked merchant")
+	engine := &AccrualEngine{
+		Rules: []AccrualRule{
+			BaseRate{PointsPerUnit: 1},
+			AccrualRuleFunc(func(accrual *Accrual) error {
+				if accrual.Purchase.Category == "gambling" {
+					return errBlocked
+				}
+				// flat bonus on every purchase
+				accrual.Points += 5
+				return nil
+			}),
+		},
+	}
+
+	points, err := engine.Calculate(Purchase{Amount: 10, Currency: "USD"})
+	s.NoError(err)
+	s.Equal(15, points)
+
+	_, err = engine.Calculate(Purchase{Amount: 10, Currency: "USD", Category: "gambling"})
+	s.ErrorIs(err, errBlocked)
+}
+
+func (s *UnitTestSuite) Test_CalculateAccrualActivity() {
+	env := s.NewTestActivityEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	result, err := env.ExecuteActivity(a.CalculateAccrual, Purchase{ID: "p1", Amount: 12.5, Currency: "USD"})
+	s.NoError(err)
+	var transaction PointsTransaction
+	s.NoError(result.Get(&transaction))
+	s.Equal(PointsTransaction{
+		ID:     "p1",
+		Amount: 12,
+		Source: SourcePurchase,
+		Reason: "Purchase of 12.50 USD ()",
+	}, transaction)
+
+	_, err = env.ExecuteActivity(a.CalculateAccrual, Purchase{ID: "p2", Amount: 12.5, Currency: "XYZ"})
+	s.Error(err)
+}
@@ -3,48 +3,376 @@ package loyalty
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
+
 	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 )
 
 type GuestInviteResult int
 
 const (
+	// GuestInvited means a new account was opened for the guest.
 	GuestInvited GuestInviteResult = iota
+	// GuestAlreadyCanceled means the guest previously had an account and closed it.
 	GuestAlreadyCanceled
+	// GuestAlreadyMember means the guest is an active member who already has the sponsored status or better.
+	GuestAlreadyMember
+	// GuestUpgraded means the guest is an active member who was promoted to the sponsored status.
+	GuestUpgraded
+)
+
+// ErrTypeInvalidPurchase is the application error type for purchases the accrual engine can't convert to points.
+const ErrTypeInvalidPurchase = "InvalidPurchase"
+
+// ErrTypeInvalidTimeZone is the application error type for contact profiles with a time zone workers don't know.
+const ErrTypeInvalidTimeZone = "InvalidTimeZone"
+
+// Application error types for SendEmail and SendNotification. Only the delivery failures are retried.
+const (
+	ErrTypeInvalidEmail               = "InvalidEmail"
+	ErrTypeEmailRejected              = "EmailRejected"
+	ErrTypeEmailDeliveryFailed        = "EmailDeliveryFailed"
+	ErrTypeNotificationRejected       = "NotificationRejected"
+	ErrTypeNotificationDeliveryFailed = "NotificationDeliveryFailed"
+)
+
+// Application error types for the guest invitation path. Only ErrTypeCorruptState fails the host's workflow; the
+// others are recorded against the invitation and the guest's slot is released.
+const (
+	ErrTypeInvalidGuest      = "InvalidGuest"
+	ErrTypeGuestStartFailed  = "GuestStartFailed"
+	ErrTypeGuestInviteFailed = "GuestInviteFailed"
+	ErrTypeCorruptGuestState = "CorruptGuestState"
 )
 
 type Activities struct {
 	Client client.Client
+	// AccrualEngine converts purchases to points. DefaultAccrualEngine is used if nil.
+	AccrualEngine *AccrualEngine
+	// Mailer delivers email. If nil, emails are only logged.
+	Mailer Mailer
+	// EmailFrom is the sender address for every email.
+	EmailFrom string
+	// SMSSender delivers text messages. If nil, they're only logged.
+	SMSSender SMSSender
+	// PushSender delivers push notifications. If nil, they're only logged.
+	PushSender PushSender
+	// Outbox records delivered notifications so retries don't send them twice. If nil, retries may duplicate
+	// messages whose delivery succeeded but wasn't acknowledged in time.
+	Outbox Outbox
+}
+
+// SendEmail renders the email in the customer's locale and sends it. Rendering happens here rather than in the
+// workflow so templates can be edited without breaking determinism. Rejected emails fail without retrying; other
+// delivery failures are retried.
+func (a *Activities) SendEmail(ctx context.Context, email Email) error {
+	logger := activity.GetLogger(ctx)
+
+	rendered, err := RenderEmail(email)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unable to render email '%v'", email.Template), ErrTypeInvalidEmail, err)
+	}
+
+	if a.Mailer == nil || email.To == "" {
+		logger.Info("Sending email.", "Subject", rendered.Subject, "Contents", rendered.Text)
+		return nil
+	}
+
+	err = a.deliverOnce(ctx, email.IdempotencyKey, func() error {
+		return a.Mailer.Send(ctx, MailMessage{
+			ID:      email.IdempotencyKey,
+			From:    a.EmailFrom,
+			To:      email.To,
+			Subject: rendered.Subject,
+			Text:    rendered.Text,
+			HTML:    rendered.HTML,
+		})
+	})
+	if errors.Is(err, ErrDeliveryRejected) {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("email to '%v' was rejected", email.To), ErrTypeEmailRejected, err)
+	} else if err != nil {
+		return temporal.NewApplicationErrorWithCause(
+			fmt.Sprintf("unable to send email to '%v'", email.To), ErrTypeEmailDeliveryFailed, err)
+	}
+
+	logger.Info("Sent email.", "To", email.To, "Subject", rendered.Subject)
+	return nil
+}
+
+// SendNotification renders the notification in the customer's language and time zone, then delivers it on each of
+// the channels their contact profile allows. Channels are independent. With an Outbox, each channel is delivered at
+// most once, so the activity is retried whenever a channel failed transiently. Without one, it only fails if no
+// channel succeeded, so that a retry can't duplicate the channels that did.
+func (a *Activities) SendNotification(ctx context.Context, notification Notification) error {
+	logger := activity.GetLogger(ctx)
+	contact := notification.Contact
+
+	if marketingTemplates[notification.Template] && !contact.MarketingConsent {
+		logger.Info("Customer hasn't consented to marketing; not sending.",
+			"CustomerID", notification.CustomerID, "Template", notification.Template)
+		return nil
+	}
+	channels := contact.channels()
+	if len(channels) == 0 {
+		logger.Info("Customer can't be reached on any channel; not sending.",
+			"CustomerID", notification.CustomerID, "Template", notification.Template)
+		return nil
+	}
+
+	params := notification.Params.in(contact.location())
+	rendered, err := RenderEmail(Email{Template: notification.Template, Locale: contact.Locale, Params: params})
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unable to render notification '%v'", notification.Template), ErrTypeInvalidEmail, err)
+	}
+
+	delivered := 0
+	var transientErr, rejectedErr error
+	for _, channel := range channels {
+		key := ""
+		if notification.IdempotencyKey != "" {
+			key = channelKey(notification.IdempotencyKey, channel)
+		}
+		err := a.deliverOnce(ctx, key, func() error {
+			return a.deliver(ctx, channel, key, notification.CustomerID, contact.address(channel), rendered)
+		})
+		if errors.Is(err, ErrDeliveryRejected) {
+			logger.Warn("Notification rejected.", "Channel", channel, "Error", err)
+			rejectedErr = err
+		} else if err != nil {
+			logger.Warn("Unable to deliver notification.", "Channel", channel, "Error", err)
+			transientErr = err
+		} else {
+			delivered++
+		}
+	}
+
+	if transientErr != nil && (delivered == 0 || a.Outbox != nil) {
+		return temporal.NewApplicationErrorWithCause(
+			fmt.Sprintf("unable to notify customer '%v'", notification.CustomerID),
+			ErrTypeNotificationDeliveryFailed, transientErr)
+	} else if delivered > 0 {
+		return nil
+	}
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("notification to customer '%v' was rejected", notification.CustomerID),
+		ErrTypeNotificationRejected, rejectedErr)
+}
+
+// deliver sends a rendered notification on one channel, or logs it if that channel has no sender configured.
+func (a *Activities) deliver(ctx context.Context, channel Channel, id string, customerID string, address string,
+	rendered RenderedEmail) error {
+	logger := activity.GetLogger(ctx)
+
+	switch {
+	case channel == ChannelEmail && a.Mailer != nil:
+		return a.Mailer.Send(ctx, MailMessage{
+			ID:      id,
+			From:    a.EmailFrom,
+			To:      address,
+			Subject: rendered.Subject,
+			Text:    rendered.Text,
+			HTML:    rendered.HTML,
+		})
+	case channel == ChannelSMS && a.SMSSender != nil:
+		return a.SMSSender.SendSMS(ctx, SMSMessage{ID: id, To: address, Body: rendered.Text})
+	case channel == ChannelPush && a.PushSender != nil:
+		return a.PushSender.SendPush(ctx, PushMessage{
+			ID:         id,
+			Endpoint:   address,
+			CustomerID: customerID,
+			Title:      rendered.Subject,
+			Body:       rendered.Text,
+		})
+	default:
+		logger.Info("Sending notification.", "Channel", channel, "To", address, "Contents", rendered.Text)
+		return nil
+	}
+}
+
+// CalculateAccrual converts a purchase into a points transaction using the configured accrual engine.
+func (a *Activities) CalculateAccrual(ctx context.Context, purchase Purchase) (PointsTransaction, error) {
+	logger := activity.GetLogger(ctx)
+
+	engine := a.AccrualEngine
+	if engine == nil {
+		engine = DefaultAccrualEngine()
+	}
+
+	points, err := engine.Calculate(purchase)
+	if err != nil {
+		return PointsTransaction{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unable to calculate points for purchase '%v'", purchase.ID), ErrTypeInvalidPurchase, err)
+	}
+
+	logger.Info("Calculated points for purchase.", "Purchase", purchase, "Points", points)
+	return PointsTransaction{
+		ID:        purchase.ID,
+		Amount:    points,
+		Source:    SourcePurchase,
+		Reason:    fmt.Sprintf("Purchase of %.2f %v (%v)", purchase.Amount, purchase.Currency, purchase.Category),
+		Timestamp: purchase.Timestamp,
+		RefundOf:  purchase.RefundOf,
+	}, nil
+}
+
+// CheckTimeZone fails if the IANA time zone name isn't known. Time zones are loaded here rather than in the workflow,
+// so the result is recorded in its history.
+func (a *Activities) CheckTimeZone(_ context.Context, name string) error {
+	_, err := time.LoadLocation(name)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("invalid time zone '%v'", name), ErrTypeInvalidTimeZone, err)
+	}
+	return nil
 }
 
-func (*Activities) SendEmail(ctx context.Context, body string) error {
+// FulfillReward hands a redemption off to the fulfillment system. Returning an error, once retries are exhausted,
+// causes the workflow to refund the customer's points.
+func (*Activities) FulfillReward(ctx context.Context, customerID string, redemption Redemption) error {
 	logger := activity.GetLogger(ctx)
-	logger.Info("Sending email.", "Contents", body)
+	logger.Info("Fulfilling reward.", "CustomerID", customerID, "Redemption", redemption)
 	return nil
 }
 
+// StartGuestWorkflow brings the guest to the status their host sponsors. The guest's existing execution, if any, is
+// described first so that each outcome can be told apart: new guests are signal-with-started, active members are
+// signaled, and guests who closed their account are left alone.
 func (a *Activities) StartGuestWorkflow(ctx context.Context, guest CustomerInfo) (GuestInviteResult, error) {
 	logger := activity.GetLogger(ctx)
 
-	workflowOptions := client.StartWorkflowOptions{
-		TaskQueue:             TaskQueue,
-		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+	if guest.CustomerID == "" {
+		return -1, temporal.NewNonRetryableApplicationError("guest ID is required", ErrTypeInvalidGuest, nil)
+	}
+	workflowID := CustomerWorkflowID(guest.CustomerID)
+	minStatus := MinimumStatus{Ordinal: guest.StatusOrdinal, SponsorID: guest.SponsorID}
+	if guest.Program != nil {
+		minStatus.Status = guest.status().Name
+	}
+
+	existing, err := a.Client.DescribeWorkflowExecution(ctx, workflowID, "")
+	notFound := &serviceerror.NotFound{}
+	if errors.As(err, &notFound) {
+		workflowOptions := client.StartWorkflowOptions{
+			TaskQueue:             TaskQueue,
+			WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		}
+
+		logger.Info("Starting and signaling guest workflow.", "GuestID", guest.CustomerID)
+		_, err = a.Client.SignalWithStartWorkflow(ctx, workflowID, SignalEnsureMinimumStatus, minStatus,
+			workflowOptions, CustomerLoyaltyWorkflow, guest, true)
+
+		// the guest closed an account between being described and being started
+		alreadyStarted := &serviceerror.WorkflowExecutionAlreadyStarted{}
+		if errors.As(err, &alreadyStarted) {
+			return GuestAlreadyCanceled, nil
+		} else if err != nil {
+			return -1, guestStartError(guest.CustomerID, err)
+		}
+		return GuestInvited, nil
+	} else if err != nil {
+		return -1, guestStartError(guest.CustomerID, err)
 	}
 
-	logger.Info("Starting and signaling guest workflow.", "GuestID", guest.CustomerID)
-	_, err := a.Client.SignalWithStartWorkflow(ctx, CustomerWorkflowID(guest.CustomerID),
-		SignalEnsureMinimumStatus, StatusLevelForPoints(guest.LoyaltyPoints).Ordinal,
-		workflowOptions, CustomerLoyaltyWorkflow, guest, true)
+	if existing.GetWorkflowExecutionInfo().GetStatus() != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		logger.Info("Guest previously closed their account.", "GuestID", guest.CustomerID)
+		return GuestAlreadyCanceled, nil
+	}
+
+	value, err := a.Client.QueryWorkflow(ctx, workflowID, "", QueryGetStatus)
+	if err != nil {
+		return -1, guestStartError(guest.CustomerID, err)
+	}
+	var status GetStatusResponse
+	err = value.Get(&status)
+	if err != nil {
+		return -1, guestStartError(guest.CustomerID, err)
+	}
+	// suspended guests are still members; closing ones are treated as gone
+	if !status.AccountActive && status.Account.State != AccountStateSuspended {
+		return GuestAlreadyCanceled, nil
+	}
 
-	target := &serviceerror.WorkflowExecutionAlreadyStarted{}
-	if errors.As(err, &target) {
+	logger.Info("Signaling existing guest workflow.", "GuestID", guest.CustomerID, "Status", status.StatusLevel.Name)
+	err = a.Client.SignalWorkflow(ctx, workflowID, "", SignalEnsureMinimumStatus, minStatus)
+	if errors.As(err, &notFound) {
 		return GuestAlreadyCanceled, nil
 	} else if err != nil {
-		return -1, err
+		return -1, guestStartError(guest.CustomerID, err)
+	}
+
+	if status.StatusLevel.Ordinal >= guest.StatusOrdinal {
+		return GuestAlreadyMember, nil
+	}
+	return GuestUpgraded, nil
+}
+
+// guestStartError maps an error from the Temporal service to a typed application error: invalid guests won't be
+// retried, anything else will.
+func guestStartError(guestID string, err error) error {
+	invalidArgument := &serviceerror.InvalidArgument{}
+	if errors.As(err, &invalidArgument) {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("invalid guest '%v'", guestID), ErrTypeInvalidGuest, err)
+	}
+	return temporal.NewApplicationErrorWithCause(
+		fmt.Sprintf("unable to start workflow for guest '%v'", guestID), ErrTypeGuestStartFailed, err)
+}
+
+// ListCustomers returns one page of running customer workflows from visibility.
+func (a *Activities) ListCustomers(ctx context.Context, pageSize int, pageToken []byte) (CustomerPage, error) {
+	query := fmt.Sprintf("WorkflowType = 'CustomerLoyaltyWorkflow' AND ExecutionStatus = 'Running' AND WorkflowId STARTS_WITH '%v'",
+		CustomerWorkflowIDPrefix)
+	resp, err := a.Client.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+		PageSize:      int32(pageSize),
+		NextPageToken: pageToken,
+		Query:         query,
+	})
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	page := CustomerPage{NextPageToken: resp.GetNextPageToken()}
+	for _, execution := range resp.GetExecutions() {
+		page.WorkflowIDs = append(page.WorkflowIDs, execution.GetExecution().GetWorkflowId())
+	}
+	return page, nil
+}
+
+// SignalCustomers sends the batch's campaign or bonus to each customer workflow. It is safe to retry: campaigns
+// replace themselves by ID, and are only announced once, and bonuses are deduplicated by transaction ID.
+func (a *Activities) SignalCustomers(ctx context.Context, batch FanOutBatch) (FanOutCounts, error) {
+	logger := activity.GetLogger(ctx)
+
+	var counts FanOutCounts
+	for i, workflowID := range batch.WorkflowIDs {
+		activity.RecordHeartbeat(ctx, i)
+
+		var err error
+		if batch.Campaign != nil {
+			err = a.Client.SignalWorkflow(ctx, workflowID, "", SignalAddCampaign, *batch.Campaign)
+		} else {
+			err = a.Client.SignalWorkflow(ctx, workflowID, "", SignalAddPoints, bonusFor(*batch.Bonus, workflowID))
+		}
+
+		target := &serviceerror.NotFound{}
+		if errors.As(err, &target) {
+			counts.Skipped++
+		} else if err != nil {
+			logger.Warn("Unable to signal customer workflow.", "WorkflowID", workflowID, "Error", err)
+			counts.Failed++
+		} else {
+			counts.Succeeded++
+		}
 	}
 
-	return GuestInvited, nil
+	return counts, nil
 }
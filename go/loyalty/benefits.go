@@ -0,0 +1,102 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Benefit names an entitlement that comes with a status level. Programs may define their own benefits beyond these.
+type Benefit string
+
+const (
+	// BenefitGuests is how many guests a customer may sponsor at once. Unlike other benefits, it isn't consumed per
+	// qualification period; a guest occupies a slot for as long as they're on the customer's guest list.
+	BenefitGuests        Benefit = "guests"
+	BenefitFreeUpgrades  Benefit = "freeUpgrades"
+	BenefitLoungePasses  Benefit = "loungePasses"
+	BenefitPriorityLines Benefit = "priorityLines"
+)
+
+// Entitlement is a customer's allowance of, and usage of, a single benefit.
+type Entitlement struct {
+	Benefit   Benefit
+	Allowed   int
+	Used      int
+	Remaining int
+}
+
+// UseBenefitRequest is the payload for SignalUseBenefit and UpdateUseBenefit. A non-empty ID makes the request
+// idempotent.
+type UseBenefitRequest struct {
+	ID      string
+	Benefit Benefit
+}
+
+// UnmarshalJSON accepts status levels serialized before benefits existed, when the guest allowance was its own field.
+func (s *StatusLevel) UnmarshalJSON(data []byte) error {
+	type statusLevel StatusLevel
+	var level struct {
+		statusLevel
+		GuestsAllowed *int
+	}
+	err := json.Unmarshal(data, &level)
+	if err != nil {
+		return err
+	}
+
+	*s = StatusLevel(level.statusLevel)
+	if level.GuestsAllowed != nil && s.Benefits == nil {
+		s.Benefits = map[Benefit]int{BenefitGuests: *level.GuestsAllowed}
+	}
+	return nil
+}
+
+func (c *CustomerInfo) benefitUsed(benefit Benefit) int {
+	if benefit == BenefitGuests {
+		return len(c.Guests)
+	}
+	return c.BenefitsUsed[benefit]
+}
+
+func (c *CustomerInfo) benefitRemaining(benefit Benefit) int {
+	remaining := c.status().Benefits[benefit] - c.benefitUsed(benefit)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func (c *CustomerInfo) useBenefit(benefit Benefit) {
+	if c.BenefitsUsed == nil {
+		c.BenefitsUsed = make(map[Benefit]int)
+	}
+	c.BenefitsUsed[benefit]++
+}
+
+// entitlements lists every benefit the customer's status grants or that they've used this period, sorted by name.
+func (c *CustomerInfo) entitlements() []Entitlement {
+	benefits := make(map[Benefit]bool)
+	for b := range c.status().Benefits {
+		benefits[b] = true
+	}
+	for b := range c.BenefitsUsed {
+		benefits[b] = true
+	}
+	if len(c.Guests) > 0 {
+		benefits[BenefitGuests] = true
+	}
+
+	var entitlements []Entitlement
+	for b := range benefits {
+		entitlements = append(entitlements, Entitlement{
+			Benefit:   b,
+			Allowed:   c.status().Benefits[b],
+			Used:      c.benefitUsed(b),
+			Remaining: c.benefitRemaining(b),
+		})
+	}
+	sort.Slice(entitlements, func(i, j int) bool {
+		return entitlements[i].Benefit < entitlements[j].Benefit
+	})
+	return entitlements
+}
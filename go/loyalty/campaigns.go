@@ -0,0 +1,95 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Campaign is a time-boxed promotion that multiplies points earned from eligible purchases.
+type Campaign struct {
+	ID         string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Multiplier float64
+	// EligibleStatuses restricts the campaign to customers at these status levels, by name, so it means the same tiers
+	// to customers pinned to different program versions. Empty means every level.
+	EligibleStatuses []string
+	// Segments restricts the campaign to customers in at least one of these segments. Empty means every customer.
+	// A customer's segments are set when their workflow starts, and can't be changed while it runs.
+	Segments []string
+}
+
+func (c Campaign) Validate() error {
+	if c.ID == "" {
+		return errors.New("campaign ID is required")
+	}
+	if !c.End.After(c.Start) {
+		return fmt.Errorf("campaign '%v' must end after it starts", c.ID)
+	}
+	if c.Multiplier <= 0 {
+		return fmt.Errorf("campaign '%v' must have a positive multiplier", c.ID)
+	}
+	return nil
+}
+
+// activeAt returns true if t falls within the campaign's [Start, End) window.
+func (c Campaign) activeAt(t time.Time) bool {
+	return !t.Before(c.Start) && t.Before(c.End)
+}
+
+func (c Campaign) eligible(customer *CustomerInfo) bool {
+	if len(c.EligibleStatuses) > 0 && !containsString(c.EligibleStatuses, customer.status().Name) {
+		return false
+	}
+	if len(c.Segments) == 0 {
+		return true
+	}
+	for _, segment := range c.Segments {
+		if containsString(customer.Segments, segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// addCampaign stores the campaign, replacing any with the same ID, and forgets campaigns that ended before now.
+// Returns true if no campaign with the same ID was stored.
+func (c *CustomerInfo) addCampaign(campaign Campaign, now time.Time) bool {
+	added := true
+	campaigns := []Campaign{campaign}
+	for _, existing := range c.Campaigns {
+		if existing.ID == campaign.ID {
+			added = false
+		} else if existing.End.After(now) {
+			campaigns = append(campaigns, existing)
+		}
+	}
+	c.Campaigns = campaigns
+	return added
+}
+
+// bestCampaign returns the eligible campaign with the highest multiplier active at t, or nil if there isn't one.
+// Campaigns don't stack.
+func (c *CustomerInfo) bestCampaign(t time.Time) *Campaign {
+	var best *Campaign
+	for i, campaign := range c.Campaigns {
+		if !campaign.activeAt(t) || !campaign.eligible(c) {
+			continue
+		}
+		if best == nil || campaign.Multiplier > best.Multiplier {
+			best = &c.Campaigns[i]
+		}
+	}
+	return best
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
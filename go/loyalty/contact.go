@@ -0,0 +1,138 @@
+package loyalty
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"time"
+	// Embedded, so every worker agrees on which time zones exist.
+	_ "time/tzdata"
+)
+
+// Channel is a way of reaching a customer.
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	// ChannelPush delivers to the customer's push endpoint, such as a mobile push gateway or a webhook.
+	ChannelPush Channel = "push"
+)
+
+// DefaultChannels are used for customers who haven't chosen any.
+var DefaultChannels = []Channel{ChannelEmail}
+
+var (
+	phonePattern  = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+)
+
+// ContactProfile is how and where a customer is notified.
+type ContactProfile struct {
+	Email string `json:",omitempty"`
+	// Phone is an E.164 number, such as "+14155550100".
+	Phone string `json:",omitempty"`
+	// PushEndpoint is the https URL push notifications are delivered to. Workers only deliver to hosts they allow;
+	// see WebhookPushSender.
+	PushEndpoint string `json:",omitempty"`
+	// Locale is the customer's language preference, such as "es" or "es-MX". Empty means DefaultLocale.
+	Locale string `json:",omitempty"`
+	// TimeZone is an IANA time zone name used to show dates, such as "America/Chicago". Empty means UTC. It's checked
+	// by the CheckTimeZone activity rather than Validate, since loading it isn't deterministic.
+	TimeZone string `json:",omitempty"`
+	// MarketingConsent allows promotional notifications, such as campaign announcements.
+	MarketingConsent bool `json:",omitempty"`
+	// Channels are the channels the customer wants to be notified on. Empty means DefaultChannels.
+	Channels []Channel `json:",omitempty"`
+	// OptOuts are channels the customer has unsubscribed from, such as by replying STOP to a text. They take
+	// precedence over Channels.
+	OptOuts []Channel `json:",omitempty"`
+}
+
+// Validate checks that every address is well-formed and that each chosen channel has an address to deliver to. It's
+// safe to call from workflow code.
+func (p ContactProfile) Validate() error {
+	if p.Email != "" {
+		address, err := mail.ParseAddress(p.Email)
+		if err != nil || address.Address != p.Email {
+			return fmt.Errorf("invalid email address '%v'", p.Email)
+		}
+	}
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		return fmt.Errorf("invalid phone number '%v'; expected E.164 format, such as '+14155550100'", p.Phone)
+	}
+	if p.PushEndpoint != "" {
+		endpoint, err := url.Parse(p.PushEndpoint)
+		if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+			return fmt.Errorf("invalid push endpoint '%v'", p.PushEndpoint)
+		}
+	}
+	if p.Locale != "" && !localePattern.MatchString(p.Locale) {
+		return fmt.Errorf("invalid locale '%v'", p.Locale)
+	}
+	for _, channel := range p.Channels {
+		if p.address(channel) == "" {
+			return fmt.Errorf("channel '%v' has no address", channel)
+		}
+	}
+	for _, channel := range p.OptOuts {
+		if !channel.valid() {
+			return fmt.Errorf("unknown channel '%v'", channel)
+		}
+	}
+	return nil
+}
+
+func (c Channel) valid() bool {
+	return c == ChannelEmail || c == ChannelSMS || c == ChannelPush
+}
+
+// address is where the channel delivers to, or empty if the customer can't be reached on it.
+func (p ContactProfile) address(channel Channel) string {
+	switch channel {
+	case ChannelEmail:
+		return p.Email
+	case ChannelSMS:
+		return p.Phone
+	case ChannelPush:
+		return p.PushEndpoint
+	default:
+		return ""
+	}
+}
+
+// channels are the channels a notification is delivered on: the customer's chosen channels they haven't opted out
+// of and have an address for.
+func (p ContactProfile) channels() []Channel {
+	chosen := p.Channels
+	if len(chosen) == 0 {
+		chosen = DefaultChannels
+	}
+
+	var channels []Channel
+	for _, channel := range chosen {
+		if p.address(channel) != "" && !p.optedOut(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func (p ContactProfile) optedOut(channel Channel) bool {
+	for _, c := range p.OptOuts {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// location is the customer's time zone, or UTC if they haven't set a valid one.
+func (p ContactProfile) location() *time.Location {
+	location, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
@@ -0,0 +1,204 @@
+package loyalty
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *UnitTestSuite) Test_ContactProfileValidate() {
+	valid := []ContactProfile{
+		{},
+		{Email: "customer@example.com", Locale: "es-MX", TimeZone: "America/Chicago"},
+		{Phone: "+14155550100", Channels: []Channel{ChannelSMS}, OptOuts: []Channel{ChannelEmail}},
+		{PushEndpoint: "https://push.example.com/customer", Channels: []Channel{ChannelPush}},
+	}
+	for _, profile := range valid {
+		s.NoError(profile.Validate(), profile)
+	}
+
+	invalid := []struct {
+		profile ContactProfile
+		message string
+	}{
+		{ContactProfile{Email: "Customer <customer@example.com>"}, "invalid email address"},
+		{ContactProfile{Email: "not an address"}, "invalid email address"},
+		{ContactProfile{Phone: "415-555-0100"}, "invalid phone number"},
+		{ContactProfile{PushEndpoint: "ftp://push.example.com"}, "invalid push endpoint"},
+		{ContactProfile{PushEndpoint: "http://push.example.com"}, "invalid push endpoint"},
+		{ContactProfile{Locale: "spanish"}, "invalid locale"},
+		{ContactProfile{Email: "customer@example.com", Channels: []Channel{ChannelSMS}}, "channel 'sms' has no address"},
+		{ContactProfile{Channels: []Channel{"fax"}}, "channel 'fax' has no address"},
+		{ContactProfile{OptOuts: []Channel{"fax"}}, "unknown channel 'fax'"},
+	}
+	for _, test := range invalid {
+		s.ErrorContains(test.profile.Validate(), test.message)
+	}
+}
+
+func (s *UnitTestSuite) Test_ContactProfileChannels() {
+	profile := ContactProfile{Email: "customer@example.com", Phone: "+14155550100"}
+	s.Equal([]Channel{ChannelEmail}, profile.channels())
+
+	profile.Channels = []Channel{ChannelEmail, ChannelSMS, ChannelPush}
+	s.Equal([]Channel{ChannelEmail, ChannelSMS}, profile.channels())
+
+	profile.OptOuts = []Channel{ChannelEmail}
+	s.Equal([]Channel{ChannelSMS}, profile.channels())
+
+	s.Empty(ContactProfile{}.channels())
+}
+
+func (s *UnitTestSuite) Test_UpdateContactProfile() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	profile := ContactProfile{
+		Email:    "new@example.com",
+		Phone:    "+14155550100",
+		Locale:   "es",
+		TimeZone: "America/Chicago",
+		Channels: []Channel{ChannelEmail, ChannelSMS},
+	}
+	invalid := &updateCallbacks{}
+	unknownZone := &updateCallbacks{}
+	valid := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateContact, invalid, ContactProfile{Phone: "555-0100"})
+		env.UpdateWorkflow(UpdateContact, unknownZone,
+			ContactProfile{Email: "new@example.com", TimeZone: "Mars/Olympus_Mons"})
+		env.UpdateWorkflow(UpdateContact, valid, profile)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetContact)
+		s.NoError(err)
+		var contact ContactProfile
+		s.NoError(result.Get(&contact))
+		s.Equal(profile, contact)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	// carried over from a previous run
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		AccountActive: true,
+		Contact:       ContactProfile{Email: "old@example.com"},
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(invalid.rejected, "invalid phone number")
+	// time zones are checked by an activity, so the update is accepted but fails
+	s.True(unknownZone.accepted)
+	s.ErrorContains(unknownZone.err, "invalid time zone 'Mars/Olympus_Mons'")
+	s.True(valid.accepted)
+	s.NoError(valid.err)
+	s.Equal(profile, valid.result)
+
+	// notifications are addressed with the profile in effect when they're sent
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, Notification{
+		CustomerID: "123",
+		Contact:    profile,
+		Template:   TemplateCancelAccount,
+		// the first notification of this run
+		IdempotencyKey: "default-test-workflow-id/default-test-run-id/1/cancelAccount",
+	})
+}
+
+func (s *UnitTestSuite) Test_CampaignAnnouncementRequiresConsent() {
+	for _, consent := range []bool{true, false} {
+		env := s.NewTestWorkflowEnvironment()
+
+		a := &Activities{}
+		env.RegisterActivity(a)
+		env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+		start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		env.SetStartTime(start)
+		campaign := Campaign{
+			ID:         "double",
+			Name:       "Double points",
+			Start:      start,
+			End:        start.Add(time.Hour * 24 * 7),
+			Multiplier: 2,
+		}
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(SignalAddCampaign, campaign)
+		}, time.Second*1)
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(SignalCancelAccount, nil)
+		}, time.Second*2)
+
+		customer := CustomerInfo{
+			CustomerID:    "123",
+			AccountActive: true,
+			Contact:       ContactProfile{Email: "customer@example.com", MarketingConsent: consent},
+		}
+		env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+		s.True(env.IsWorkflowCompleted())
+		s.NoError(env.GetWorkflowError())
+
+		if consent {
+			env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(campaignAnnouncementEmail(campaign)))
+		} else {
+			env.AssertNotCalled(s.T(), "SendNotification", mock.Anything,
+				notified(campaignAnnouncementEmail(campaign)))
+		}
+	}
+}
+
+func (s *UnitTestSuite) Test_CampaignAnnouncedOnce() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var sent []Notification
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(1).(Notification))
+		}).
+		Return(nil)
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+	campaign := Campaign{
+		ID:         "double",
+		Name:       "Double points",
+		Start:      start,
+		End:        start.Add(time.Hour * 24 * 7),
+		Multiplier: 2,
+	}
+
+	// a retried fan-out batch sends the same campaign again
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddCampaign, campaign)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddCampaign, campaign)
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		AccountActive: true,
+		Contact:       ContactProfile{Email: "customer@example.com", MarketingConsent: true},
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	announced := 0
+	for _, notification := range sent {
+		if notification.Template == TemplateCampaignAnnouncement {
+			announced++
+		}
+	}
+	s.Equal(1, announced)
+}
@@ -0,0 +1,87 @@
+package loyalty
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// Priority decides whether a notification waits for the customer's digest or goes out straight away.
+type Priority int
+
+const (
+	// PriorityLow notifications are batched into a digest when the program has a DigestQuietPeriod.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal notifications are sent straight away.
+	PriorityNormal
+	// PriorityCritical notifications are sent straight away, and anything waiting in the digest follows them so
+	// nothing is left behind, e.g. when the account is closed.
+	PriorityCritical
+)
+
+// templatePriorities classifies templates that aren't PriorityNormal.
+var templatePriorities = map[TemplateName]Priority{
+	TemplatePromoted:       PriorityLow,
+	TemplateDemoted:        PriorityLow,
+	TemplateStatusRetained: PriorityLow,
+	TemplateBenefitUsed:    PriorityLow,
+	TemplatePointsExpiring: PriorityLow,
+	TemplatePointsExpired:  PriorityLow,
+	TemplateAccountClosing: PriorityCritical,
+	TemplateCancelAccount:  PriorityCritical,
+}
+
+func (t TemplateName) priority() Priority {
+	priority, ok := templatePriorities[t]
+	if !ok {
+		return PriorityNormal
+	}
+	return priority
+}
+
+// DefaultDigestMaxDelay caps how long a digest waits for programs without a DigestMaxDelay.
+const DefaultDigestMaxDelay = 24 * time.Hour
+
+// queueDigest adds a low-priority notification to the customer's digest and restarts its quiet period, but never
+// past the program's maximum delay from the first notification in it, so a steady trickle still gets sent.
+func queueDigest(ctx workflow.Context, customer *CustomerInfo, email Email) {
+	now := workflow.Now(ctx)
+	if len(customer.PendingDigest) == 0 || customer.DigestStartedAt.IsZero() {
+		customer.DigestStartedAt = now
+	}
+	customer.PendingDigest = append(customer.PendingDigest, email)
+
+	maxDelay := customer.Program.DigestMaxDelay
+	if maxDelay == 0 {
+		maxDelay = DefaultDigestMaxDelay
+	}
+	customer.DigestDueAt = now.Add(customer.Program.DigestQuietPeriod)
+	if deadline := customer.DigestStartedAt.Add(maxDelay); deadline.Before(customer.DigestDueAt) {
+		customer.DigestDueAt = deadline
+	}
+}
+
+// flushDigest sends whatever is waiting in the customer's digest: a lone notification as itself, several as one
+// digest. Failures are logged and the digest is dropped, as for any other notification.
+func flushDigest(ctx workflow.Context, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	pending := customer.PendingDigest
+	customer.PendingDigest = nil
+	customer.DigestStartedAt = time.Time{}
+	customer.DigestDueAt = time.Time{}
+
+	var email Email
+	switch len(pending) {
+	case 0:
+		return
+	case 1:
+		email = pending[0]
+	default:
+		email = digestEmail(pending)
+	}
+	err := sendNotification(ctx, customer, email)
+	if err != nil {
+		logger.Error("Error running SendNotification activity for digest.", "Error", err)
+	}
+}
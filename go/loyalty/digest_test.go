@@ -0,0 +1,279 @@
+package loyalty
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *UnitTestSuite) Test_RenderDigest() {
+	expiresAt := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	digest := digestEmail([]Email{promotedEmail("Gold"), pointsExpiringEmail(100, expiresAt)})
+
+	rendered, err := RenderEmail(digest)
+	s.NoError(err)
+	s.Equal("Your loyalty program updates", rendered.Subject)
+	s.Equal("Here's what's new with your account:\n"+
+		"- Congratulations! You've been promoted to 'Gold' status!\n"+
+		"- Heads up! 100 of your points will expire on March 5, 2024.", rendered.Text)
+	s.Contains(rendered.HTML, "<li>Congratulations! You&#39;ve been promoted to &#39;Gold&#39; status!</li>")
+
+	// items are rendered in the digest's language
+	digest.Locale = "es"
+	rendered, err = RenderEmail(digest)
+	s.NoError(err)
+	s.Equal("Esto es lo nuevo en tu cuenta:\n"+
+		"- ¡Felicidades! Has subido al estado 'Gold'.\n"+
+		"- ¡Atención! 100 de tus puntos caducarán el 05/03/2024.", rendered.Text)
+}
+
+func (s *UnitTestSuite) Test_NotificationPriority() {
+	s.Equal(PriorityLow, TemplatePromoted.priority())
+	s.Equal(PriorityNormal, TemplateGuestInvited.priority())
+	s.Equal(PriorityCritical, TemplateCancelAccount.priority())
+	s.Equal(PriorityCritical, TemplateAccountClosing.priority())
+}
+
+func (s *UnitTestSuite) Test_DigestBatchesLowPriority() {
+	env := s.NewTestWorkflowEnvironment()
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var sent []Notification
+	var sentAt []time.Time
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(1).(Notification))
+			sentAt = append(sentAt, env.Now().UTC())
+		}).
+		Return(nil)
+
+	// a burst of signals, each crossing into the next tier
+	for i := 1; i < len(statusLevels); i++ {
+		points := statusLevels[i].MinimumPoints - statusLevels[i-1].MinimumPoints
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(SignalAddPoints, points)
+		}, time.Minute*time.Duration(i))
+	}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*3)
+
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	var promotions []Email
+	for _, level := range statusLevels[1:] {
+		promotions = append(promotions, promotedEmail(level.Name))
+	}
+	s.Len(sent, 2)
+	s.Equal(TemplateDigest, sent[0].Template)
+	s.Equal(promotions, sent[0].Params.Digest)
+	// an hour after the last promotion
+	last := time.Minute * time.Duration(len(statusLevels)-1)
+	s.Equal(start.Add(last+time.Hour), sentAt[0])
+	s.Equal(TemplateCancelAccount, sent[1].Template)
+}
+
+func (s *UnitTestSuite) Test_CriticalNotificationFlushesDigest() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var templates []TemplateName
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			templates = append(templates, args.Get(1).(Notification).Template)
+		}).
+		Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[1].MinimumPoints)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the cancellation isn't held up, and the lone promotion follows as itself
+	s.Equal([]TemplateName{TemplateCancelAccount, TemplatePromoted}, templates)
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(promotedEmail(statusLevels[1].Name)))
+}
+
+func (s *UnitTestSuite) Test_DigestSurvivesContinueAsNew() {
+	env := s.NewTestWorkflowEnvironment()
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*2)
+
+	// carried over from a previous run
+	pending := []Email{promotedEmail(statusLevels[1].Name), promotedEmail(statusLevels[2].Name)}
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+		StatusOrdinal: 2,
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		PendingDigest: pending,
+		DigestDueAt:   start.Add(time.Minute * 30),
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(digestEmail(pending)))
+	env.AssertNumberOfCalls(s.T(), "SendNotification", 2)
+}
+
+func (s *UnitTestSuite) Test_DigestMaxDelay() {
+	env := s.NewTestWorkflowEnvironment()
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var sent []Notification
+	var sentAt []time.Time
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(1).(Notification))
+			sentAt = append(sentAt, env.Now().UTC())
+		}).
+		Return(nil)
+
+	// a promotion every 50 minutes never lets the hour-long quiet period pass
+	for i := 1; i < len(statusLevels); i++ {
+		points := statusLevels[i].MinimumPoints - statusLevels[i-1].MinimumPoints
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(SignalAddPoints, points)
+		}, time.Minute*50*time.Duration(i))
+	}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*6)
+
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	program.DigestMaxDelay = time.Hour * 2
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the first three went out two hours after the first of them; the last started a new digest
+	s.Len(sent, 3)
+	s.Equal(TemplateDigest, sent[0].Template)
+	s.Equal([]Email{
+		promotedEmail(statusLevels[1].Name),
+		promotedEmail(statusLevels[2].Name),
+		promotedEmail(statusLevels[3].Name),
+	}, sent[0].Params.Digest)
+	s.Equal(start.Add(time.Minute*50+time.Hour*2), sentAt[0])
+	s.Equal(TemplatePromoted, sent[1].Template)
+	s.Equal(start.Add(time.Minute*200+time.Hour), sentAt[1])
+	s.Equal(TemplateCancelAccount, sent[2].Template)
+}
+
+func (s *UnitTestSuite) Test_DigestFlushedOnCancellation() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var templates []TemplateName
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			templates = append(templates, args.Get(1).(Notification).Template)
+		}).
+		Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[1].MinimumPoints)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.CancelWorkflow()
+	}, time.Second*2)
+
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+
+	// the run ended well before the quiet period did
+	s.Equal([]TemplateName{TemplatePromoted}, templates)
+}
+
+func (s *UnitTestSuite) Test_DigestFlushedWhenAccountCloses() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var templates []TemplateName
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			templates = append(templates, args.Get(1).(Notification).Template)
+		}).
+		Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[1].MinimumPoints)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	program := DefaultProgram()
+	program.DigestQuietPeriod = time.Hour
+	program.CancellationGracePeriod = time.Minute
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the closing notice doesn't leave the promotion waiting out its quiet period
+	s.Equal([]TemplateName{TemplateAccountClosing, TemplatePromoted, TemplateCancelAccount}, templates)
+}
@@ -0,0 +1,145 @@
+package loyalty
+
+import "time"
+
+// ExpirationPolicy configures when redeemable points expire. Qualifying points are never affected. The zero value
+// disables expiration.
+type ExpirationPolicy struct {
+	// InactivityPeriod expires the entire balance once the customer hasn't earned points for this long.
+	InactivityPeriod time.Duration `yaml:"inactivityPeriod"`
+	// BatchLifetime expires each batch of earned points this long after it was earned.
+	BatchLifetime time.Duration `yaml:"batchLifetime"`
+	// WarningLead is how far ahead of an expiry the customer is warned about it.
+	WarningLead time.Duration `yaml:"warningLead"`
+}
+
+func (p ExpirationPolicy) enabled() bool {
+	return p.InactivityPeriod > 0 || p.BatchLifetime > 0
+}
+
+// expiresAt returns the earlier of the batch's own expiry and the inactivity expiry.
+func (p ExpirationPolicy) expiresAt(batch PointsBatch, lastEarnedAt time.Time) time.Time {
+	var expiry time.Time
+	if p.BatchLifetime > 0 {
+		expiry = batch.EarnedAt.Add(p.BatchLifetime)
+	}
+	if p.InactivityPeriod > 0 {
+		inactive := lastEarnedAt.Add(p.InactivityPeriod)
+		if expiry.IsZero() || inactive.Before(expiry) {
+			expiry = inactive
+		}
+	}
+	return expiry
+}
+
+// PointsBatch is a group of points earned together, and which expire together.
+type PointsBatch struct {
+	Points   int
+	EarnedAt time.Time
+	Warned   bool
+}
+
+// earnBatch tracks newly earned points for expiration. It's a no-op when expiration is disabled. Under an inactivity
+// period, earning pushes back when earlier batches expire, so any warning about their old expiry no longer applies.
+func (c *CustomerInfo) earnBatch(points int, now time.Time) {
+	policy := c.Program.PointsExpiration
+	if !policy.enabled() || points <= 0 {
+		return
+	}
+	for i := range c.PointBatches {
+		b := &c.PointBatches[i]
+		if b.Warned && !policy.expiresAt(*b, now).Equal(policy.expiresAt(*b, c.LastEarnedAt)) {
+			b.Warned = false
+		}
+	}
+	c.PointBatches = append(c.PointBatches, PointsBatch{Points: points, EarnedAt: now})
+	c.LastEarnedAt = now
+}
+
+// spendBatches takes spent points from the oldest batches first.
+func (c *CustomerInfo) spendBatches(points int) {
+	for points > 0 && len(c.PointBatches) > 0 {
+		oldest := &c.PointBatches[0]
+		if oldest.Points > points {
+			oldest.Points -= points
+			return
+		}
+		points -= oldest.Points
+		c.PointBatches = c.PointBatches[1:]
+	}
+}
+
+// reconcileBatches puts any balance not yet in a batch, e.g. points earned before expiration was enabled, into a new
+// batch earned now.
+func (c *CustomerInfo) reconcileBatches(now time.Time) {
+	if !c.Program.PointsExpiration.enabled() {
+		return
+	}
+	tracked := 0
+	for _, b := range c.PointBatches {
+		tracked += b.Points
+	}
+	if c.LastEarnedAt.IsZero() {
+		c.LastEarnedAt = now
+	}
+	if untracked := c.LoyaltyPoints - tracked; untracked > 0 {
+		c.PointBatches = append(c.PointBatches, PointsBatch{Points: untracked, EarnedAt: now})
+	}
+}
+
+// nextExpirationEvent returns when the next warning or expiry is due, or the zero time if no points will expire.
+func (c *CustomerInfo) nextExpirationEvent() time.Time {
+	policy := c.Program.PointsExpiration
+	var next time.Time
+	for _, b := range c.PointBatches {
+		event := policy.expiresAt(b, c.LastEarnedAt)
+		if !b.Warned && policy.WarningLead > 0 {
+			event = event.Add(-policy.WarningLead)
+		}
+		if next.IsZero() || event.Before(next) {
+			next = event
+		}
+	}
+	return next
+}
+
+// expireBatches removes every batch that has expired by now from the balance, returning the number of points lost.
+func (c *CustomerInfo) expireBatches(now time.Time) int {
+	expired := 0
+	remaining := c.PointBatches[:0]
+	for _, b := range c.PointBatches {
+		if now.Before(c.Program.PointsExpiration.expiresAt(b, c.LastEarnedAt)) {
+			remaining = append(remaining, b)
+		} else {
+			expired += b.Points
+		}
+	}
+	c.PointBatches = remaining
+
+	if expired > c.LoyaltyPoints {
+		expired = c.LoyaltyPoints
+	}
+	c.LoyaltyPoints -= expired
+	return expired
+}
+
+// warnBatches marks every batch that's due a warning by now as warned, returning the number of points that will
+// expire and when the first of them does.
+func (c *CustomerInfo) warnBatches(now time.Time) (int, time.Time) {
+	policy := c.Program.PointsExpiration
+	points := 0
+	var firstExpiry time.Time
+	for i := range c.PointBatches {
+		b := &c.PointBatches[i]
+		expiry := policy.expiresAt(*b, c.LastEarnedAt)
+		if b.Warned || now.Before(expiry.Add(-policy.WarningLead)) {
+			continue
+		}
+		b.Warned = true
+		points += b.Points
+		if firstExpiry.IsZero() || expiry.Before(firstExpiry) {
+			firstExpiry = expiry
+		}
+	}
+	return points, firstExpiry
+}
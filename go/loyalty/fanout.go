@@ -0,0 +1,167 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	QueryGetFanOutProgress = "getFanOutProgress"
+
+	DefaultFanOutBatchSize = 100
+	// DefaultFanOutBatchInterval paces fan-outs that don't set a BatchInterval, so they don't flood the cluster.
+	DefaultFanOutBatchInterval = time.Second
+	// DefaultFanOutPagesPerRun bounds how many pages a single fan-out run processes before continuing as new.
+	DefaultFanOutPagesPerRun = 500
+)
+
+// FanOutRequest describes what to send to every running customer workflow, and carries the fan-out's checkpoint
+// across continue-as-new. Exactly one of Campaign or Bonus must be set.
+type FanOutRequest struct {
+	Campaign *Campaign
+	// Bonus is credited to every customer. Each customer's transaction ID is derived from Bonus.ID so that re-sent
+	// signals are deduplicated by the customer workflow.
+	Bonus *PointsTransaction
+
+	// BatchSize is the number of customers listed and signaled at a time; BatchInterval is how long to wait between
+	// batches. Zero uses DefaultFanOutBatchSize and DefaultFanOutBatchInterval.
+	BatchSize     int
+	BatchInterval time.Duration
+	PagesPerRun   int
+
+	// NextPageToken and Counts are the checkpoint; leave them empty when starting a new fan-out.
+	NextPageToken []byte
+	Counts        FanOutCounts
+}
+
+type FanOutCounts struct {
+	Succeeded int
+	Failed    int
+	// Skipped counts customers whose workflow closed between being listed and being signaled.
+	Skipped int
+}
+
+func (c *FanOutCounts) add(other FanOutCounts) {
+	c.Succeeded += other.Succeeded
+	c.Failed += other.Failed
+	c.Skipped += other.Skipped
+}
+
+// CustomerPage is one page of running customer workflow IDs.
+type CustomerPage struct {
+	WorkflowIDs   []string
+	NextPageToken []byte
+}
+
+// FanOutBatch is a set of customer workflows to signal with the same request.
+type FanOutBatch struct {
+	WorkflowIDs []string
+	Campaign    *Campaign
+	Bonus       *PointsTransaction
+}
+
+func (r *FanOutRequest) Validate() error {
+	if (r.Campaign == nil) == (r.Bonus == nil) {
+		return errors.New("exactly one of campaign or bonus is required")
+	}
+	if r.BatchInterval < 0 {
+		return fmt.Errorf("batch interval can't be negative, got %v", r.BatchInterval)
+	}
+	if r.Campaign != nil {
+		return r.Campaign.Validate()
+	}
+	if r.Bonus.ID == "" {
+		return errors.New("bonus transaction ID is required")
+	}
+	if r.Bonus.Amount == 0 {
+		return fmt.Errorf("bonus '%v' must have a non-zero amount", r.Bonus.ID)
+	}
+	return nil
+}
+
+// bonusFor returns the bonus transaction for a single customer, with an ID unique to that customer.
+func bonusFor(bonus PointsTransaction, workflowID string) PointsTransaction {
+	bonus.ID = bonus.ID + ":" + workflowID
+	return bonus
+}
+
+// CampaignFanOutWorkflow signals every running customer workflow with a campaign or bonus points, one rate-limited
+// batch at a time. Progress is queryable and is carried across continue-as-new, so the fan-out scales to any
+// number of customers.
+func CampaignFanOutWorkflow(ctx workflow.Context, request FanOutRequest) (FanOutCounts, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Fan-out workflow started.", "Request", request)
+
+	err := request.Validate()
+	if err != nil {
+		return request.Counts, temporal.NewNonRetryableApplicationError("invalid fan-out request", "InvalidFanOut", err)
+	}
+	if request.BatchSize <= 0 {
+		request.BatchSize = DefaultFanOutBatchSize
+	}
+	// fan-outs started before the default was introduced weren't paced
+	if request.BatchInterval == 0 &&
+		workflow.GetVersion(ctx, "fan-out-batch-interval", workflow.DefaultVersion, 1) == 1 {
+		request.BatchInterval = DefaultFanOutBatchInterval
+	}
+	if request.PagesPerRun <= 0 {
+		request.PagesPerRun = DefaultFanOutPagesPerRun
+	}
+
+	err = workflow.SetQueryHandler(ctx, QueryGetFanOutProgress, func() (FanOutCounts, error) {
+		return request.Counts, nil
+	})
+	if err != nil {
+		return request.Counts, err
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		HeartbeatTimeout:    10 * time.Second,
+	})
+
+	var activities *Activities
+	for page := 0; page < request.PagesPerRun; page++ {
+		var customers CustomerPage
+		err = workflow.ExecuteActivity(ctx, activities.ListCustomers, request.BatchSize, request.NextPageToken).
+			Get(ctx, &customers)
+		if err != nil {
+			logger.Error("Error running ListCustomers activity.", "Error", err)
+			return request.Counts, err
+		}
+
+		if len(customers.WorkflowIDs) > 0 {
+			batch := FanOutBatch{
+				WorkflowIDs: customers.WorkflowIDs,
+				Campaign:    request.Campaign,
+				Bonus:       request.Bonus,
+			}
+			var counts FanOutCounts
+			err = workflow.ExecuteActivity(ctx, activities.SignalCustomers, batch).Get(ctx, &counts)
+			if err != nil {
+				logger.Error("Error running SignalCustomers activity.", "Error", err)
+				counts = FanOutCounts{Failed: len(batch.WorkflowIDs)}
+			}
+			request.Counts.add(counts)
+		}
+
+		request.NextPageToken = customers.NextPageToken
+		if len(request.NextPageToken) == 0 {
+			logger.Info("Fan-out workflow completed.", "Counts", request.Counts)
+			return request.Counts, nil
+		}
+
+		if request.BatchInterval > 0 {
+			err = workflow.Sleep(ctx, request.BatchInterval)
+			if err != nil {
+				return request.Counts, err
+			}
+		}
+	}
+
+	return request.Counts, workflow.NewContinueAsNewError(ctx, CampaignFanOutWorkflow, request)
+}
@@ -0,0 +1,107 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/workflow"
+)
+
+func (s *UnitTestSuite) Test_CampaignFanOut() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.ListCustomers, mock.Anything, 2, []byte(nil)).
+		Return(CustomerPage{WorkflowIDs: []string{"customer-1", "customer-2"}, NextPageToken: []byte("page-2")}, nil)
+	env.OnActivity(a.ListCustomers, mock.Anything, 2, []byte("page-2")).
+		Return(CustomerPage{WorkflowIDs: []string{"customer-3"}}, nil)
+	env.OnActivity(a.SignalCustomers, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, batch FanOutBatch) (FanOutCounts, error) {
+			s.NotNil(batch.Campaign)
+			if len(batch.WorkflowIDs) == 1 {
+				return FanOutCounts{Skipped: 1}, nil
+			}
+			return FanOutCounts{Succeeded: 1, Failed: 1}, nil
+		})
+
+	start := env.Now()
+	request := FanOutRequest{
+		Campaign:  &Campaign{ID: "double-weekend", Start: start, End: start.AddDate(0, 0, 2), Multiplier: 2},
+		BatchSize: 2,
+	}
+	env.ExecuteWorkflow(CampaignFanOutWorkflow, request)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	var counts FanOutCounts
+	s.NoError(env.GetWorkflowResult(&counts))
+	s.Equal(FanOutCounts{Succeeded: 1, Failed: 1, Skipped: 1}, counts)
+	// paced by the default interval between the two batches
+	s.Equal(DefaultFanOutBatchInterval, env.Now().Sub(start))
+}
+
+func (s *UnitTestSuite) Test_CampaignFanOutContinuesAsNew() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.ListCustomers, mock.Anything, mock.Anything, mock.Anything).
+		Return(CustomerPage{WorkflowIDs: []string{"customer-1"}, NextPageToken: []byte("page-2")}, nil)
+	env.OnActivity(a.SignalCustomers, mock.Anything, mock.Anything).
+		Return(FanOutCounts{Succeeded: 1}, nil)
+
+	request := FanOutRequest{
+		Bonus:       &PointsTransaction{ID: "anniversary", Amount: 500, Source: SourceAdjustment},
+		PagesPerRun: 1,
+	}
+	env.ExecuteWorkflow(CampaignFanOutWorkflow, request)
+	s.True(env.IsWorkflowCompleted())
+	s.True(workflow.IsContinueAsNewError(env.GetWorkflowError()))
+}
+
+func (s *UnitTestSuite) Test_CampaignFanOutInvalidRequest() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	env.ExecuteWorkflow(CampaignFanOutWorkflow, FanOutRequest{})
+	s.True(env.IsWorkflowCompleted())
+	s.ErrorContains(env.GetWorkflowError(), "invalid fan-out request")
+
+	request := FanOutRequest{
+		Bonus:         &PointsTransaction{ID: "anniversary", Amount: 500},
+		BatchInterval: -time.Second,
+	}
+	s.ErrorContains(request.Validate(), "batch interval can't be negative")
+}
+
+func (s *UnitTestSuite) Test_SignalCustomersActivity() {
+	env := s.NewTestActivityEnvironment()
+
+	c := &mocks.Client{}
+	c.On("SignalWorkflow", mock.Anything, "customer-1", "", SignalAddPoints,
+		PointsTransaction{ID: "anniversary:customer-1", Amount: 500}).Return(nil)
+	c.On("SignalWorkflow", mock.Anything, "customer-2", "", SignalAddPoints, mock.Anything).
+		Return(serviceerror.NewNotFound("workflow not found"))
+	c.On("SignalWorkflow", mock.Anything, "customer-3", "", SignalAddPoints, mock.Anything).
+		Return(errors.New("connection reset"))
+
+	a := &Activities{Client: c}
+	env.RegisterActivity(a)
+
+	batch := FanOutBatch{
+		WorkflowIDs: []string{"customer-1", "customer-2", "customer-3"},
+		Bonus:       &PointsTransaction{ID: "anniversary", Amount: 500},
+	}
+	result, err := env.ExecuteActivity(a.SignalCustomers, batch)
+	s.NoError(err)
+
+	var counts FanOutCounts
+	s.NoError(result.Get(&counts))
+	s.Equal(FanOutCounts{Succeeded: 1, Failed: 1, Skipped: 1}, counts)
+	c.AssertExpectations(s.T())
+}
@@ -0,0 +1,313 @@
+package loyalty
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// MinimumStatus is the payload of SignalEnsureMinimumStatus. SponsorID is the host whose invitation granted the
+// status, if any.
+type MinimumStatus struct {
+	Ordinal int
+	// Status is the level's name. The receiver may be pinned to a different program than the sender, so the level is
+	// matched by name when there is one, and only by Ordinal otherwise.
+	Status    string `json:",omitempty"`
+	SponsorID string
+}
+
+// UnmarshalJSON accepts either a full MinimumStatus or, for callers that predate sponsorship, a bare status ordinal.
+func (m *MinimumStatus) UnmarshalJSON(data []byte) error {
+	var ordinal int
+	if err := json.Unmarshal(data, &ordinal); err == nil {
+		*m = MinimumStatus{Ordinal: ordinal}
+		return nil
+	}
+
+	type minimumStatus MinimumStatus
+	return json.Unmarshal(data, (*minimumStatus)(m))
+}
+
+// InviteGuestRequest is the payload of SignalInviteGuest and UpdateInviteGuest. Contact is where the guest's
+// invitation code is sent; it's required by programs that make guests accept, and never shared with the host.
+type InviteGuestRequest struct {
+	GuestID string
+	Contact ContactProfile
+}
+
+// UnmarshalJSON accepts either a full InviteGuestRequest or, for callers that predate invitation codes, a bare guest
+// ID.
+func (r *InviteGuestRequest) UnmarshalJSON(data []byte) error {
+	var guestID string
+	if err := json.Unmarshal(data, &guestID); err == nil {
+		*r = InviteGuestRequest{GuestID: guestID}
+		return nil
+	}
+
+	type inviteGuestRequest InviteGuestRequest
+	return json.Unmarshal(data, (*inviteGuestRequest)(r))
+}
+
+// checkGuestContact makes sure a guest who has to accept an invitation can be sent their code. Guests who are enrolled
+// straight away don't need a contact.
+func (c *CustomerInfo) checkGuestContact(request InviteGuestRequest) error {
+	if c.Program.GuestInvitationTTL <= 0 || (c.hasGuest(request.GuestID) && c.guestAccepted(request.GuestID)) {
+		return nil
+	}
+	err := request.Contact.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid contact for guest '%v': %w", request.GuestID, err)
+	}
+	if len(request.Contact.channels()) == 0 {
+		return fmt.Errorf("guest '%v' has no contact to send their invitation code to", request.GuestID)
+	}
+	return nil
+}
+
+func (c *CustomerInfo) hasGuest(guestID string) bool {
+	for _, g := range c.Guests {
+		if g == guestID {
+			return true
+		}
+	}
+	return false
+}
+
+// removeGuest drops the guest, freeing their slot. Returns false if they weren't a guest.
+func (c *CustomerInfo) removeGuest(guestID string) bool {
+	for i, g := range c.Guests {
+		if g == guestID {
+			c.Guests = append(c.Guests[:i], c.Guests[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type InvitationState string
+
+const (
+	InvitationPending  InvitationState = "pending"
+	InvitationAccepted InvitationState = "accepted"
+	// InvitationFailed invitations no longer hold a slot; they're kept so the host can see what went wrong.
+	InvitationFailed InvitationState = "failed"
+)
+
+// GuestInvitation tracks a guest from invitation through acceptance. A pending invitation holds one of the host's
+// guest slots until it is accepted or expires.
+type GuestInvitation struct {
+	GuestID    string
+	Code       string
+	State      InvitationState
+	InvitedAt  time.Time
+	ExpiresAt  time.Time
+	AcceptedAt time.Time
+	// FailureReason explains why a failed invitation couldn't be completed.
+	FailureReason string
+	// Status is the guest's latest status as reported by their own workflow, if it has reported yet.
+	Status *GuestStatus
+}
+
+// GuestStatus is a guest's live status, sent by the guest's workflow to their sponsor whenever it changes.
+type GuestStatus struct {
+	GuestID       string
+	StatusOrdinal int
+	StatusLevel   string
+	AccountActive bool
+	UpdatedAt     time.Time
+}
+
+// GuestRecord is the host's complete view of one of their guests.
+type GuestRecord struct {
+	GuestID    string
+	State      InvitationState
+	InvitedAt  time.Time
+	AcceptedAt time.Time
+	ExpiresAt  time.Time
+	// StatusLevel and AccountActive are empty until the guest's workflow first reports its status.
+	StatusLevel     string
+	AccountActive   bool
+	StatusUpdatedAt time.Time
+}
+
+// AcceptInvitationRequest is sent on the host's workflow by a guest accepting their invitation.
+type AcceptInvitationRequest struct {
+	GuestID string
+	Code    string
+}
+
+func (c *CustomerInfo) invitation(guestID string) *GuestInvitation {
+	for i := range c.Invitations {
+		if c.Invitations[i].GuestID == guestID {
+			return &c.Invitations[i]
+		}
+	}
+	return nil
+}
+
+func (c *CustomerInfo) removeInvitation(guestID string) {
+	for i, invitation := range c.Invitations {
+		if invitation.GuestID == guestID {
+			c.Invitations = append(c.Invitations[:i], c.Invitations[i+1:]...)
+			return
+		}
+	}
+}
+
+// nextInvitationExpiry returns when the earliest pending invitation expires, or the zero time if none are pending.
+func (c *CustomerInfo) nextInvitationExpiry() time.Time {
+	var next time.Time
+	for _, invitation := range c.Invitations {
+		if invitation.State == InvitationPending && (next.IsZero() || invitation.ExpiresAt.Before(next)) {
+			next = invitation.ExpiresAt
+		}
+	}
+	return next
+}
+
+// failInvitation records why the guest couldn't be invited and releases their slot.
+func (c *CustomerInfo) failInvitation(guestID string, reason string) {
+	c.removeGuest(guestID)
+	invitation := c.invitation(guestID)
+	if invitation == nil {
+		c.Invitations = append(c.Invitations, GuestInvitation{GuestID: guestID})
+		invitation = &c.Invitations[len(c.Invitations)-1]
+	}
+	invitation.State = InvitationFailed
+	invitation.Code = ""
+	invitation.FailureReason = reason
+}
+
+// endSponsorship forgets the customer's sponsor, and the status floor their sponsorship granted.
+func (c *CustomerInfo) endSponsorship() {
+	c.SponsorID = ""
+	c.SponsoredOrdinal = 0
+	c.ReportedToSponsor = GuestStatus{}
+}
+
+// checkGuestState returns an ErrTypeCorruptGuestState error if the customer's guest bookkeeping can't be trusted.
+func (c *CustomerInfo) checkGuestState() error {
+	if c.StatusOrdinal < 0 || c.StatusOrdinal >= len(c.Program.StatusLevels) {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("customer '%v' has unknown status ordinal %v", c.CustomerID, c.StatusOrdinal),
+			ErrTypeCorruptGuestState, nil)
+	}
+	seen := make(map[string]bool)
+	for _, guestID := range c.Guests {
+		if seen[guestID] {
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("customer '%v' lists guest '%v' more than once", c.CustomerID, guestID),
+				ErrTypeCorruptGuestState, nil)
+		}
+		seen[guestID] = true
+	}
+	return nil
+}
+
+// isCorruptGuestState returns true if err means the host's state is too broken to keep running.
+func isCorruptGuestState(err error) bool {
+	var appErr *temporal.ApplicationError
+	return errors.As(err, &appErr) && appErr.Type() == ErrTypeCorruptGuestState
+}
+
+// expireInvitations drops every pending invitation that has expired by now, releasing the guest slots they held,
+// and returns the IDs of the guests whose invitations expired.
+func (c *CustomerInfo) expireInvitations(now time.Time) []string {
+	var expired []string
+	var invitations []GuestInvitation
+	for _, invitation := range c.Invitations {
+		if invitation.State == InvitationPending && !invitation.ExpiresAt.After(now) {
+			expired = append(expired, invitation.GuestID)
+			c.removeGuest(invitation.GuestID)
+			continue
+		}
+		invitations = append(invitations, invitation)
+	}
+	c.Invitations = invitations
+	return expired
+}
+
+// newInvitationCode returns a random code for a guest to accept an invitation with.
+func newInvitationCode(ctx workflow.Context) (string, error) {
+	var code string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		b := make([]byte, 4)
+		_, err := rand.Read(b)
+		if err != nil {
+			return ""
+		}
+		return strings.ToUpper(hex.EncodeToString(b))
+	}).Get(&code)
+	if err != nil {
+		return "", err
+	}
+	if code == "" {
+		return "", errors.New("unable to generate invitation code")
+	}
+	return code, nil
+}
+
+// guestAccepted returns true if the guest has accepted their invitation. Guests invited before invitations were
+// tracked are treated as accepted.
+func (c *CustomerInfo) guestAccepted(guestID string) bool {
+	invitation := c.invitation(guestID)
+	return invitation == nil || invitation.State == InvitationAccepted
+}
+
+// guestRecords describes every guest holding one of the customer's slots.
+func (c *CustomerInfo) guestRecords() []GuestRecord {
+	records := make([]GuestRecord, 0, len(c.Guests))
+	for _, guestID := range c.Guests {
+		record := GuestRecord{GuestID: guestID, State: InvitationAccepted}
+		if invitation := c.invitation(guestID); invitation != nil {
+			record.State = invitation.State
+			record.InvitedAt = invitation.InvitedAt
+			record.AcceptedAt = invitation.AcceptedAt
+			record.ExpiresAt = invitation.ExpiresAt
+			if invitation.Status != nil {
+				record.StatusLevel = invitation.Status.StatusLevel
+				record.AccountActive = invitation.Status.AccountActive
+				record.StatusUpdatedAt = invitation.Status.UpdatedAt
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// updateGuestStatus records a guest's reported status. Returns false if they aren't this customer's guest.
+func (c *CustomerInfo) updateGuestStatus(status GuestStatus) bool {
+	if !c.hasGuest(status.GuestID) {
+		return false
+	}
+	invitation := c.invitation(status.GuestID)
+	if invitation == nil {
+		// guests invited before invitations were tracked
+		c.Invitations = append(c.Invitations, GuestInvitation{GuestID: status.GuestID, State: InvitationAccepted})
+		invitation = &c.Invitations[len(c.Invitations)-1]
+	}
+	invitation.Status = &status
+	return true
+}
+
+// sponsorStatus returns what this customer should report to their sponsor, and whether it differs from what was
+// last reported.
+func (c *CustomerInfo) sponsorStatus() (GuestStatus, bool) {
+	status := GuestStatus{
+		GuestID:       c.CustomerID,
+		StatusOrdinal: c.StatusOrdinal,
+		StatusLevel:   c.status().Name,
+		AccountActive: c.AccountActive,
+	}
+	reported := c.ReportedToSponsor
+	changed := reported.StatusOrdinal != status.StatusOrdinal || reported.AccountActive != status.AccountActive ||
+		reported.UpdatedAt.IsZero()
+	return status, changed
+}
@@ -0,0 +1,492 @@
+package loyalty
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/temporal"
+)
+
+func (s *UnitTestSuite) Test_InviteGuestStartFailureKeepsHostAlive() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Return(GuestInviteResult(-1), temporal.NewApplicationError("frontend unavailable", ErrTypeGuestStartFailed))
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.True(state.AccountActive)
+
+		result, err = env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+
+		var guests []string
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Empty(guests)
+
+		result, err = env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		err = result.Get(&invitations)
+		s.NoError(err)
+		s.Len(invitations, 1)
+		s.Equal(InvitationFailed, invitations[0].State)
+		s.Contains(invitations[0].FailureReason, "frontend unavailable")
+	}, time.Hour)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour+time.Second)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// retried per the activity's retry policy before giving up
+	env.AssertNumberOfCalls(s.T(), "StartGuestWorkflow", 10)
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInviteFailedEmail("guest")))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+}
+
+func (s *UnitTestSuite) Test_InviteGuestInvalidGuest() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Return(GuestInviteResult(-1), temporal.NewNonRetryableApplicationError("invalid guest", ErrTypeInvalidGuest, nil))
+
+	updated := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateInviteGuest, updated, "not a valid id")
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetEntitlements)
+		s.NoError(err)
+
+		var entitlements []Entitlement
+		err = result.Get(&entitlements)
+		s.NoError(err)
+		s.Equal(Entitlement{Benefit: BenefitGuests, Allowed: 1, Used: 0, Remaining: 1}, entitlements[0])
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	var appErr *temporal.ApplicationError
+	s.True(errors.As(updated.err, &appErr))
+	s.Equal(ErrTypeGuestInviteFailed, appErr.Type())
+	env.AssertNumberOfCalls(s.T(), "StartGuestWorkflow", 1)
+}
+
+func (s *UnitTestSuite) Test_InviteGuestRequiresContact() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	program := DefaultProgram()
+	program.GuestInvitationTTL = time.Hour * 24
+
+	noContact := &updateCallbacks{}
+	badContact := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateInviteGuest, noContact, "guest")
+		env.UpdateWorkflow(UpdateInviteGuest, badContact, InviteGuestRequest{
+			GuestID: "guest",
+			Contact: ContactProfile{Email: "not an address"},
+		})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		s.NoError(result.Get(&invitations))
+		s.Empty(invitations)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(noContact.rejected, "guest 'guest' has no contact to send their invitation code to")
+	s.ErrorContains(badContact.rejected, "invalid contact for guest 'guest'")
+}
+
+func (s *UnitTestSuite) Test_AcceptInvitationStartFailure() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	var code string
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			if notification := args.Get(1).(Notification); notification.Template == TemplateGuestInvitation {
+				code = notification.Params.Code
+			}
+		}).
+		Return(nil)
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Return(GuestInviteResult(-1), temporal.NewNonRetryableApplicationError("invalid guest", ErrTypeInvalidGuest, nil))
+
+	program := DefaultProgram()
+	program.GuestInvitationTTL = time.Hour * 24
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, InviteGuestRequest{
+			GuestID: "guest",
+			Contact: ContactProfile{Email: "guest@example.com"},
+		})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAcceptInvitation, AcceptInvitationRequest{GuestID: "guest", Code: code})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		err = result.Get(&invitations)
+		s.NoError(err)
+		s.Len(invitations, 1)
+		s.Equal(InvitationFailed, invitations[0].State)
+	}, time.Second*3)
+
+	// the failed invitation no longer has a deadline to enforce
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*48)
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInviteFailedEmail("guest")))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(invitationExpiredEmail("guest")))
+}
+
+func (s *UnitTestSuite) Test_InviteGuestCorruptStateFailsWorkflow() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+	}, time.Second*1)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[3].MinimumPoints,
+		Guests:        []string{"twin", "twin"},
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+
+	var appErr *temporal.ApplicationError
+	s.True(errors.As(env.GetWorkflowError(), &appErr))
+	s.Equal(ErrTypeCorruptGuestState, appErr.Type())
+	env.AssertNotCalled(s.T(), "StartGuestWorkflow", mock.Anything, mock.Anything)
+}
+
+func (s *UnitTestSuite) Test_StartGuestWorkflowErrors() {
+	env := s.NewTestActivityEnvironment()
+
+	c := &mocks.Client{}
+	c.On("DescribeWorkflowExecution", mock.Anything, mock.Anything, "").
+		Return(nil, serviceerror.NewNotFound("workflow not found"))
+	c.On("SignalWithStartWorkflow", mock.Anything, CustomerWorkflowID("bad"), mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, serviceerror.NewInvalidArgument("bad workflow ID"))
+	c.On("SignalWithStartWorkflow", mock.Anything, CustomerWorkflowID("flaky"), mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, serviceerror.NewUnavailable("try again"))
+
+	a := &Activities{Client: c}
+	env.RegisterActivity(a)
+
+	tests := []struct {
+		guestID   string
+		errType   string
+		retryable bool
+	}{
+		{guestID: "", errType: ErrTypeInvalidGuest},
+		{guestID: "bad", errType: ErrTypeInvalidGuest},
+		{guestID: "flaky", errType: ErrTypeGuestStartFailed, retryable: true},
+	}
+	for _, test := range tests {
+		_, err := env.ExecuteActivity(a.StartGuestWorkflow, CustomerInfo{CustomerID: test.guestID})
+
+		var appErr *temporal.ApplicationError
+		s.True(errors.As(err, &appErr), test.guestID)
+		s.Equal(test.errType, appErr.Type(), test.guestID)
+		s.Equal(!test.retryable, appErr.NonRetryable(), test.guestID)
+	}
+}
+
+func (s *UnitTestSuite) Test_StartGuestWorkflowOutcomes() {
+	env := s.NewTestActivityEnvironment()
+
+	describe := func(status enums.WorkflowExecutionStatus) *workflowservice.DescribeWorkflowExecutionResponse {
+		return &workflowservice.DescribeWorkflowExecutionResponse{
+			WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{Status: status},
+		}
+	}
+	statusValue := func(level *StatusLevel) *mocks.Value {
+		value := &mocks.Value{}
+		value.On("Get", mock.Anything).
+			Run(func(args mock.Arguments) {
+				*args.Get(0).(*GetStatusResponse) = GetStatusResponse{StatusLevel: *level, AccountActive: true}
+			}).
+			Return(nil)
+		return value
+	}
+
+	c := &mocks.Client{}
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("new"), "").
+		Return(nil, serviceerror.NewNotFound("workflow not found"))
+	c.On("SignalWithStartWorkflow", mock.Anything, CustomerWorkflowID("new"), SignalEnsureMinimumStatus,
+		MinimumStatus{Ordinal: 2, SponsorID: "host"}, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, nil)
+
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("closed"), "").
+		Return(describe(enums.WORKFLOW_EXECUTION_STATUS_COMPLETED), nil)
+
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("gold"), "").
+		Return(describe(enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil)
+	c.On("QueryWorkflow", mock.Anything, CustomerWorkflowID("gold"), "", QueryGetStatus).
+		Return(statusValue(statusLevels[3]), nil)
+	c.On("SignalWorkflow", mock.Anything, CustomerWorkflowID("gold"), "", SignalEnsureMinimumStatus,
+		MinimumStatus{Ordinal: 2, SponsorID: "host"}).Return(nil)
+
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("member"), "").
+		Return(describe(enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil)
+	c.On("QueryWorkflow", mock.Anything, CustomerWorkflowID("member"), "", QueryGetStatus).
+		Return(statusValue(statusLevels[0]), nil)
+	c.On("SignalWorkflow", mock.Anything, CustomerWorkflowID("member"), "", SignalEnsureMinimumStatus,
+		MinimumStatus{Ordinal: 2, SponsorID: "host"}).Return(nil)
+
+	a := &Activities{Client: c}
+	env.RegisterActivity(a)
+
+	tests := map[string]GuestInviteResult{
+		"new":    GuestInvited,
+		"closed": GuestAlreadyCanceled,
+		"gold":   GuestAlreadyMember,
+		"member": GuestUpgraded,
+	}
+	for guestID, expected := range tests {
+		guest := CustomerInfo{CustomerID: guestID, StatusOrdinal: 2, SponsorID: "host"}
+		result, err := env.ExecuteActivity(a.StartGuestWorkflow, guest)
+		s.NoError(err, guestID)
+
+		var inviteResult GuestInviteResult
+		s.NoError(result.Get(&inviteResult))
+		s.Equal(expected, inviteResult, guestID)
+	}
+	c.AssertExpectations(s.T())
+}
+
+func (s *UnitTestSuite) Test_InviteGuestOutcomeEmails() {
+	tests := map[GuestInviteResult]Email{
+		GuestInvited:         guestInvitedEmail(),
+		GuestAlreadyCanceled: guestCanceledEmail(),
+		GuestAlreadyMember:   guestAlreadyMemberEmail(),
+		GuestUpgraded:        guestUpgradedEmail(),
+	}
+	for inviteResult, email := range tests {
+		env := s.NewTestWorkflowEnvironment()
+
+		a := &Activities{}
+		env.RegisterActivity(a)
+		env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+		env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).Return(inviteResult, nil)
+
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(SignalInviteGuest, "guest")
+		}, time.Second*1)
+		env.RegisterDelayedCallback(func() {
+			result, err := env.QueryWorkflow(QueryGetGuests)
+			s.NoError(err)
+
+			var guests []string
+			err = result.Get(&guests)
+			s.NoError(err)
+			// a guest who closed their account doesn't hold a slot
+			if inviteResult == GuestAlreadyCanceled {
+				s.Empty(guests)
+			} else {
+				s.Equal([]string{"guest"}, guests)
+			}
+
+			env.SignalWorkflow(SignalCancelAccount, nil)
+		}, time.Second*2)
+
+		customer := CustomerInfo{
+			CustomerID:    "host",
+			LoyaltyPoints: statusLevels[3].MinimumPoints,
+			AccountActive: true,
+		}
+		env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+		s.True(env.IsWorkflowCompleted())
+		s.NoError(env.GetWorkflowError())
+		env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(email))
+	}
+}
+
+func (s *UnitTestSuite) Test_InviteGuestAsChildWorkflow() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(CustomerLoyaltyWorkflow)
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	// re-inviting a guest whose child is already running goes through the activity
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).Return(GuestAlreadyMember, nil).Once()
+	// the child reports its status to its host
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(nil)
+
+	program := DefaultProgram()
+	program.GuestsAsChildWorkflows = true
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+	}, time.Second*2)
+
+	// the child is abandoned rather than closed with its parent, so cancel both to not timeout
+	env.RegisterDelayedCallback(func() {
+		s.NoError(env.SignalWorkflowByID(CustomerWorkflowID("guest"), SignalCancelAccount, nil))
+	}, time.Second*3)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*4)
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[3].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the child welcomed the guest at the status one below their host's
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(welcomeEmail(statusLevels[2].Name)))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestAlreadyMemberEmail()))
+	env.AssertNumberOfCalls(s.T(), "StartGuestWorkflow", 1)
+}
+
+func (s *UnitTestSuite) Test_EnsureMinimumStatusAcrossPrograms() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+	env.OnActivity("SendNotification", mock.Anything, mock.Anything).Return(nil)
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(nil)
+
+	// the host's program has more levels than the guest's
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalEnsureMinimumStatus, MinimumStatus{Ordinal: 4, SponsorID: "host"})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var state GetStatusResponse
+		s.NoError(result.Get(&state))
+		s.Equal("Basic", state.StatusLevel.Name)
+
+		// a level the guest's program doesn't have isn't matched by its ordinal either
+		env.SignalWorkflow(SignalEnsureMinimumStatus, MinimumStatus{Ordinal: 1, Status: "Gold", SponsorID: "host"})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var state GetStatusResponse
+		s.NoError(result.Get(&state))
+		s.Equal("Basic", state.StatusLevel.Name)
+
+		env.SignalWorkflow(SignalEnsureMinimumStatus, MinimumStatus{Ordinal: 3, Status: "Elite", SponsorID: "host"})
+	}, time.Second*3)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var state GetStatusResponse
+		s.NoError(result.Get(&state))
+		s.Equal("Elite", state.StatusLevel.Name)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*4)
+
+	guest := CustomerInfo{
+		Program: &Program{
+			Version: 7,
+			StatusLevels: []*StatusLevel{
+				newStatusLevel(0, "Basic", 0, nil),
+				newStatusLevel(1, "Elite", 50, nil),
+			},
+			QualificationPeriod: time.Hour * 24 * 30,
+		},
+		CustomerID:    "guest",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
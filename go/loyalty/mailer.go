@@ -0,0 +1,255 @@
+package loyalty
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/sdk/log"
+	"golang.org/x/time/rate"
+)
+
+// ErrDeliveryRejected is wrapped by delivery errors that retrying won't fix, such as a malformed or unknown
+// recipient. Any other error from a Mailer, SMSSender or PushSender is treated as transient.
+var ErrDeliveryRejected = errors.New("delivery rejected")
+
+// MailMessage is a rendered email addressed to one recipient.
+type MailMessage struct {
+	// ID is the message's idempotency key. It's used to derive the Message-ID header, so relays and mail clients
+	// that deduplicate on it drop repeats.
+	ID      string
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers email. Implementations must be safe for concurrent use by activities.
+type Mailer interface {
+	Send(ctx context.Context, message MailMessage) error
+}
+
+// SMTPMailer delivers email through an SMTP relay, upgrading to TLS when the server supports it.
+type SMTPMailer struct {
+	// Addr is the relay's host:port.
+	Addr string
+	// Auth is used if the server supports authentication. May be nil.
+	Auth smtp.Auth
+	// Timeout bounds the whole conversation with the server, in addition to the context's deadline. Defaults to 30s.
+	Timeout time.Duration
+	// Logger records problems that don't fail the send. May be nil.
+	Logger log.Logger
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message MailMessage) error {
+	from, to, err := parseAddresses(message)
+	if err != nil {
+		return err
+	}
+	data, err := formatMessage(message)
+	if err != nil {
+		return err
+	}
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return fmt.Errorf("unable to connect to SMTP server '%v': %w", m.Addr, err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return smtpError(err)
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && m.Auth != nil {
+		err = c.Auth(m.Auth)
+		if err != nil {
+			return smtpError(err)
+		}
+	}
+
+	err = c.Mail(from)
+	if err != nil {
+		return smtpError(err)
+	}
+	err = c.Rcpt(to)
+	if err != nil {
+		return smtpError(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return smtpError(err)
+	}
+	err = w.Close()
+	if err != nil {
+		return smtpError(err)
+	}
+
+	// The server has accepted the message, so failing now would only cause a retry to send it again.
+	err = c.Quit()
+	if err != nil && m.Logger != nil {
+		m.Logger.Warn("SMTP QUIT failed after the message was accepted.", "Addr", m.Addr, "Error", err)
+	}
+	return nil
+}
+
+// smtpError marks permanent (5xx) SMTP replies with ErrDeliveryRejected. Transient (4xx) replies and connection
+// failures are returned as-is.
+func smtpError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return fmt.Errorf("%w: %v", ErrDeliveryRejected, err)
+	}
+	return err
+}
+
+// FileMailer writes each message to its own .eml file in Dir instead of sending it, for local development.
+type FileMailer struct {
+	Dir string
+
+	sequence atomic.Int64
+}
+
+func (m *FileMailer) Send(_ context.Context, message MailMessage) error {
+	_, _, err := parseAddresses(message)
+	if err != nil {
+		return err
+	}
+	data, err := formatMessage(message)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%06d.eml", time.Now().UTC().Format("20060102T150405.000000000"), m.sequence.Add(1))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
+
+// RateLimitedMailer limits how quickly the wrapped Mailer sends, so a burst of notifications doesn't trip the
+// provider's own limits. Sends wait for capacity until their context is done.
+type RateLimitedMailer struct {
+	Mailer  Mailer
+	Limiter *rate.Limiter
+}
+
+// NewRateLimitedMailer allows perSecond sends per second on average, with bursts of up to burst.
+func NewRateLimitedMailer(m Mailer, perSecond float64, burst int) *RateLimitedMailer {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitedMailer{Mailer: m, Limiter: rate.NewLimiter(rate.Limit(perSecond), burst)}
+}
+
+func (m *RateLimitedMailer) Send(ctx context.Context, message MailMessage) error {
+	err := m.Limiter.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("rate limited: %w", err)
+	}
+	return m.Mailer.Send(ctx, message)
+}
+
+func parseAddresses(message MailMessage) (string, string, error) {
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: invalid sender '%v': %v", ErrDeliveryRejected, message.From, err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: invalid recipient '%v': %v", ErrDeliveryRejected, message.To, err)
+	}
+	return from.Address, to.Address, nil
+}
+
+// formatMessage builds a multipart/alternative message with the text and HTML bodies.
+func formatMessage(message MailMessage) ([]byte, error) {
+	var b strings.Builder
+	w := multipart.NewWriter(&b)
+
+	headers := []string{
+		"From: " + message.From,
+		"To: " + message.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+	}
+	if message.ID != "" {
+		sum := sha256.Sum256([]byte(message.ID))
+		headers = append(headers, fmt.Sprintf("Message-ID: <%v@loyalty>", hex.EncodeToString(sum[:16])))
+	}
+	headers = append(headers, "Content-Type: multipart/alternative; boundary="+w.Boundary())
+	b.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	}
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = pw.Write([]byte(strings.ReplaceAll(part.body, "\n", "\r\n")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
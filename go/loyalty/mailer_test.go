@@ -0,0 +1,202 @@
+package loyalty
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// smtpSink is a minimal local SMTP server that records the messages it accepts. Recipients listed in replies get
+// that reply to RCPT instead of being accepted, and a "QUIT" entry replaces the reply to QUIT.
+type smtpSink struct {
+	listener net.Listener
+	replies  map[string]string
+	messages chan string
+}
+
+func newSMTPSink(s *UnitTestSuite, replies map[string]string) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	sink := &smtpSink{listener: listener, replies: replies, messages: make(chan string, 10)}
+	go sink.serve()
+	s.T().Cleanup(func() { _ = listener.Close() })
+	return sink
+}
+
+func (sink *smtpSink) serve() {
+	for {
+		conn, err := sink.listener.Accept()
+		if err != nil {
+			return
+		}
+		go sink.handle(conn)
+	}
+}
+
+func (sink *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = fmt.Fprintf(conn, "%v\r\n", line) }
+
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 sink")
+		case strings.HasPrefix(command, "MAIL FROM"):
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO"):
+			recipient := strings.ToLower(strings.Trim(strings.TrimSpace(line[len("RCPT TO:"):]), "<>"))
+			if r, ok := sink.replies[recipient]; ok {
+				reply(r)
+			} else {
+				reply("250 OK")
+			}
+		case command == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			sink.messages <- data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			if r, ok := sink.replies["QUIT"]; ok {
+				reply(r)
+			} else {
+				reply("221 bye")
+			}
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *UnitTestSuite) Test_SMTPMailer() {
+	sink := newSMTPSink(s, map[string]string{
+		"unknown@example.com": "550 no such user",
+		"full@example.com":    "452 mailbox full",
+	})
+	mailer := &SMTPMailer{Addr: sink.listener.Addr().String()}
+	message := MailMessage{
+		ID:      "customer-123/run-1/1/welcome",
+		From:    "Loyalty <loyalty@example.com>",
+		To:      "customer@example.com",
+		Subject: "Welcome",
+		Text:    "Hello!",
+		HTML:    "<p>Hello!</p>",
+	}
+
+	s.NoError(mailer.Send(context.Background(), message))
+	data := <-sink.messages
+	// every attempt at the same message carries the same Message-ID, for relays to deduplicate on
+	s.Contains(data, "Message-ID: <8ae1276ff8e83ab85570e62ba8585015@loyalty>")
+	s.Contains(data, "To: customer@example.com")
+	s.Contains(data, "Subject: Welcome")
+	s.Contains(data, "Hello!")
+	s.Contains(data, "<p>Hello!</p>")
+
+	message.To = "unknown@example.com"
+	s.ErrorIs(mailer.Send(context.Background(), message), ErrDeliveryRejected)
+
+	message.To = "full@example.com"
+	err := mailer.Send(context.Background(), message)
+	s.Error(err)
+	s.NotErrorIs(err, ErrDeliveryRejected)
+
+	message.To = "not an address"
+	s.ErrorIs(mailer.Send(context.Background(), message), ErrDeliveryRejected)
+
+	// once the message is accepted, a failed QUIT mustn't cause a retry
+	sink = newSMTPSink(s, map[string]string{"QUIT": "554 goodbye failed"})
+	mailer.Addr = sink.listener.Addr().String()
+	message.To = "customer@example.com"
+	s.NoError(mailer.Send(context.Background(), message))
+	s.Contains(<-sink.messages, "Hello!")
+}
+
+func (s *UnitTestSuite) Test_FileMailer() {
+	dir := s.T().TempDir()
+	mailer := &FileMailer{Dir: filepath.Join(dir, "outbox")}
+
+	message := MailMessage{From: "loyalty@example.com", To: "customer@example.com", Subject: "Hi", Text: "Hello!"}
+	s.NoError(mailer.Send(context.Background(), message))
+	s.NoError(mailer.Send(context.Background(), message))
+
+	files, err := os.ReadDir(mailer.Dir)
+	s.NoError(err)
+	s.Len(files, 2)
+	data, err := os.ReadFile(filepath.Join(mailer.Dir, files[0].Name()))
+	s.NoError(err)
+	s.Contains(string(data), "To: customer@example.com")
+	s.Contains(string(data), "Hello!")
+}
+
+type mailerFunc func(ctx context.Context, message MailMessage) error
+
+func (f mailerFunc) Send(ctx context.Context, message MailMessage) error {
+	return f(ctx, message)
+}
+
+func (s *UnitTestSuite) Test_SendEmailActivityDelivery() {
+	env := s.NewTestActivityEnvironment()
+
+	var sent []MailMessage
+	var sendErr error
+	a := &Activities{
+		EmailFrom: "loyalty@example.com",
+		Mailer: mailerFunc(func(_ context.Context, message MailMessage) error {
+			sent = append(sent, message)
+			return sendErr
+		}),
+	}
+	env.RegisterActivity(a)
+
+	email := welcomeEmail("Member")
+	email.To = "customer@example.com"
+	_, err := env.ExecuteActivity(a.SendEmail, email)
+	s.NoError(err)
+	s.Len(sent, 1)
+	s.Equal("loyalty@example.com", sent[0].From)
+	s.Equal("customer@example.com", sent[0].To)
+	s.Equal("Welcome to our loyalty program", sent[0].Subject)
+
+	sendErr = fmt.Errorf("%w: 550 no such user", ErrDeliveryRejected)
+	_, err = env.ExecuteActivity(a.SendEmail, email)
+	var appErr *temporal.ApplicationError
+	s.ErrorAs(err, &appErr)
+	s.Equal(ErrTypeEmailRejected, appErr.Type())
+	s.True(appErr.NonRetryable())
+
+	sendErr = errors.New("connection refused")
+	_, err = env.ExecuteActivity(a.SendEmail, email)
+	s.ErrorAs(err, &appErr)
+	s.Equal(ErrTypeEmailDeliveryFailed, appErr.Type())
+	s.False(appErr.NonRetryable())
+
+	// without a recipient the email is only logged
+	sent = nil
+	_, err = env.ExecuteActivity(a.SendEmail, welcomeEmail("Member"))
+	s.NoError(err)
+	s.Empty(sent)
+}
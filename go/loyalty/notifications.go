@@ -0,0 +1,192 @@
+package loyalty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Notification is one logical message to a customer. The SendNotification activity renders it in the customer's
+// language and delivers it on every channel their contact profile allows.
+type Notification struct {
+	CustomerID string
+	Contact    ContactProfile
+	Template   TemplateName
+	Params     EmailParams
+	// IdempotencyKey identifies this notification across activity retries; see notificationKey.
+	IdempotencyKey string `json:",omitempty"`
+}
+
+// marketingTemplates are promotional, and only sent to customers who consented to marketing.
+var marketingTemplates = map[TemplateName]bool{
+	TemplateCampaignAnnouncement: true,
+}
+
+// SMSMessage is a text message to one phone number.
+type SMSMessage struct {
+	// ID is the message's idempotency key, for providers that can deduplicate.
+	ID   string
+	To   string
+	Body string
+}
+
+// SMSSender delivers text messages. Errors wrapping ErrDeliveryRejected aren't retried.
+type SMSSender interface {
+	SendSMS(ctx context.Context, message SMSMessage) error
+}
+
+// PushMessage is a push notification for one endpoint.
+type PushMessage struct {
+	// ID is the message's idempotency key. Webhooks also receive it in the Idempotency-Key header.
+	ID         string `json:"id,omitempty"`
+	Endpoint   string `json:"-"`
+	CustomerID string `json:"customerId"`
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+}
+
+// PushSender delivers push notifications. Errors wrapping ErrDeliveryRejected aren't retried.
+type PushSender interface {
+	SendPush(ctx context.Context, message PushMessage) error
+}
+
+// WebhookPushSender delivers push notifications by POSTing them as JSON to the customer's endpoint. Endpoints are
+// supplied by customers, so only https endpoints on AllowedHosts are sent to, including after redirects; anything
+// else is rejected rather than letting customers point the worker at internal services.
+type WebhookPushSender struct {
+	// Client is used for requests. http.DefaultClient is used if nil.
+	Client *http.Client
+	// AllowedHosts are the host names push endpoints may be on, such as push gateways. A name starting with a dot,
+	// such as ".push.example.com", allows any of its subdomains.
+	AllowedHosts []string
+}
+
+func (s *WebhookPushSender) SendPush(ctx context.Context, message PushMessage) error {
+	endpoint, err := url.Parse(message.Endpoint)
+	if err == nil {
+		err = s.checkEndpoint(endpoint)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: push endpoint '%v' isn't allowed: %v", ErrDeliveryRejected, message.Endpoint, err)
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: invalid push endpoint '%v': %v", ErrDeliveryRejected, message.Endpoint, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if message.ID != "" {
+		request.Header.Set("Idempotency-Key", message.ID)
+	}
+
+	client := http.DefaultClient
+	if s.Client != nil {
+		client = s.Client
+	}
+	// follow redirects only to endpoints that would have been allowed in the first place
+	checked := *client
+	checked.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		err := s.checkEndpoint(request.URL)
+		if err != nil {
+			return fmt.Errorf("%w: push endpoint '%v' redirected to '%v': %v", ErrDeliveryRejected,
+				message.Endpoint, request.URL, err)
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(request, via)
+		} else if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	response, err := checked.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests ||
+		response.StatusCode >= 500:
+		return fmt.Errorf("push endpoint '%v' returned %v", message.Endpoint, response.Status)
+	default:
+		return fmt.Errorf("%w: push endpoint '%v' returned %v", ErrDeliveryRejected, message.Endpoint, response.Status)
+	}
+}
+
+// checkEndpoint fails unless the endpoint uses https and is on one of AllowedHosts.
+func (s *WebhookPushSender) checkEndpoint(endpoint *url.URL) error {
+	if endpoint.Scheme != "https" {
+		return errors.New("only https endpoints are allowed")
+	}
+	host := strings.ToLower(endpoint.Hostname())
+	for _, allowed := range s.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host '%v' isn't on the allowlist", host)
+}
+
+// MemorySender records messages instead of delivering them, so every channel can be exercised offline. It's a
+// Mailer, an SMSSender and a PushSender.
+type MemorySender struct {
+	mu   sync.Mutex
+	mail []MailMessage
+	sms  []SMSMessage
+	push []PushMessage
+}
+
+func (s *MemorySender) Send(_ context.Context, message MailMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mail = append(s.mail, message)
+	return nil
+}
+
+func (s *MemorySender) SendSMS(_ context.Context, message SMSMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sms = append(s.sms, message)
+	return nil
+}
+
+func (s *MemorySender) SendPush(_ context.Context, message PushMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push = append(s.push, message)
+	return nil
+}
+
+// Mail returns the email sent so far.
+func (s *MemorySender) Mail() []MailMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MailMessage(nil), s.mail...)
+}
+
+// SMS returns the text messages sent so far.
+func (s *MemorySender) SMS() []SMSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMSMessage(nil), s.sms...)
+}
+
+// Push returns the push notifications sent so far.
+func (s *MemorySender) Push() []PushMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PushMessage(nil), s.push...)
+}
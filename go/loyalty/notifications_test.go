@@ -0,0 +1,181 @@
+package loyalty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+func (s *UnitTestSuite) Test_SendNotificationActivity() {
+	env := s.NewTestActivityEnvironment()
+
+	sender := &MemorySender{}
+	a := &Activities{
+		EmailFrom:  "loyalty@example.com",
+		Mailer:     sender,
+		SMSSender:  sender,
+		PushSender: sender,
+	}
+	env.RegisterActivity(a)
+
+	// midnight UTC is still the previous day in Chicago
+	expiresAt := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	notification := Notification{
+		CustomerID: "123",
+		Contact: ContactProfile{
+			Email:        "customer@example.com",
+			Phone:        "+14155550100",
+			PushEndpoint: "https://push.example.com/123",
+			Locale:       "es-MX",
+			TimeZone:     "America/Chicago",
+			Channels:     []Channel{ChannelEmail, ChannelSMS, ChannelPush},
+			OptOuts:      []Channel{ChannelSMS},
+		},
+		Template: TemplatePointsExpiring,
+		Params:   pointsExpiringEmail(100, expiresAt).Params,
+	}
+	_, err := env.ExecuteActivity(a.SendNotification, notification)
+	s.NoError(err)
+
+	s.Empty(sender.SMS())
+	s.Len(sender.Mail(), 1)
+	s.Equal("customer@example.com", sender.Mail()[0].To)
+	s.Equal("¡Atención! 100 de tus puntos caducarán el 04/03/2024.", sender.Mail()[0].Text)
+	s.Equal([]PushMessage{{
+		Endpoint:   "https://push.example.com/123",
+		CustomerID: "123",
+		Title:      "Tus puntos caducarán pronto",
+		Body:       "¡Atención! 100 de tus puntos caducarán el 04/03/2024.",
+	}}, sender.Push())
+
+	// marketing needs consent
+	campaign := campaignAnnouncementEmail(Campaign{Name: "Double points", Multiplier: 2, End: expiresAt})
+	notification.Template = campaign.Template
+	notification.Params = campaign.Params
+	_, err = env.ExecuteActivity(a.SendNotification, notification)
+	s.NoError(err)
+	s.Len(sender.Mail(), 1)
+
+	notification.Contact.MarketingConsent = true
+	_, err = env.ExecuteActivity(a.SendNotification, notification)
+	s.NoError(err)
+	s.Len(sender.Mail(), 2)
+	s.Equal("Double points: gana 2x puntos", sender.Mail()[1].Subject)
+}
+
+type smsSenderFunc func(ctx context.Context, message SMSMessage) error
+
+func (f smsSenderFunc) SendSMS(ctx context.Context, message SMSMessage) error {
+	return f(ctx, message)
+}
+
+func (s *UnitTestSuite) Test_SendNotificationActivityFailures() {
+	env := s.NewTestActivityEnvironment()
+
+	var mailErr, smsErr error
+	a := &Activities{
+		EmailFrom: "loyalty@example.com",
+		Mailer: mailerFunc(func(context.Context, MailMessage) error {
+			return mailErr
+		}),
+		SMSSender: smsSenderFunc(func(context.Context, SMSMessage) error {
+			return smsErr
+		}),
+	}
+	env.RegisterActivity(a)
+
+	notification := Notification{
+		CustomerID: "123",
+		Contact: ContactProfile{
+			Email:    "customer@example.com",
+			Phone:    "+14155550100",
+			Channels: []Channel{ChannelEmail, ChannelSMS},
+		},
+		Template: TemplateCancelAccount,
+	}
+
+	// one channel is enough
+	mailErr = errors.New("connection refused")
+	_, err := env.ExecuteActivity(a.SendNotification, notification)
+	s.NoError(err)
+
+	var appErr *temporal.ApplicationError
+	smsErr = fmt.Errorf("%w: unknown number", ErrDeliveryRejected)
+	_, err = env.ExecuteActivity(a.SendNotification, notification)
+	s.ErrorAs(err, &appErr)
+	s.Equal(ErrTypeNotificationDeliveryFailed, appErr.Type())
+	s.False(appErr.NonRetryable())
+
+	mailErr = fmt.Errorf("%w: no such user", ErrDeliveryRejected)
+	_, err = env.ExecuteActivity(a.SendNotification, notification)
+	s.ErrorAs(err, &appErr)
+	s.Equal(ErrTypeNotificationRejected, appErr.Type())
+	s.True(appErr.NonRetryable())
+
+	// customers without any reachable channel are skipped
+	_, err = env.ExecuteActivity(a.SendNotification, Notification{CustomerID: "123", Template: TemplateCancelAccount})
+	s.NoError(err)
+}
+
+func (s *UnitTestSuite) Test_WebhookPushSender() {
+	var received PushMessage
+	var idempotencyKey string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/internal":
+			http.Redirect(w, r, "https://metadata.internal/latest", http.StatusTemporaryRedirect)
+		default:
+			idempotencyKey = r.Header.Get("Idempotency-Key")
+			s.NoError(json.NewDecoder(r.Body).Decode(&received))
+		}
+	}))
+	defer server.Close()
+
+	sender := &WebhookPushSender{Client: server.Client(), AllowedHosts: []string{"127.0.0.1"}}
+	message := PushMessage{Endpoint: server.URL + "/ok", CustomerID: "123", Title: "Hi", Body: "Hello!"}
+	s.NoError(sender.SendPush(context.Background(), message))
+	s.Equal(PushMessage{CustomerID: "123", Title: "Hi", Body: "Hello!"}, received)
+	s.Empty(idempotencyKey)
+
+	message.ID = "customer-123/run-1/1/welcome/push"
+	s.NoError(sender.SendPush(context.Background(), message))
+	s.Equal("customer-123/run-1/1/welcome/push", idempotencyKey)
+	message.ID = ""
+
+	message.Endpoint = server.URL + "/gone"
+	s.ErrorIs(sender.SendPush(context.Background(), message), ErrDeliveryRejected)
+
+	message.Endpoint = server.URL + "/busy"
+	err := sender.SendPush(context.Background(), message)
+	s.Error(err)
+	s.NotErrorIs(err, ErrDeliveryRejected)
+
+	// customers can only point the worker at allowed hosts, over https, even by redirecting
+	received = PushMessage{}
+	for _, endpoint := range []string{
+		"http://127.0.0.1/ok",
+		"https://169.254.169.254/latest/meta-data",
+		"https://127.0.0.1.attacker.example/ok",
+		server.URL + "/internal",
+	} {
+		message.Endpoint = endpoint
+		s.ErrorIs(sender.SendPush(context.Background(), message), ErrDeliveryRejected, endpoint)
+	}
+	s.Empty(received)
+
+	// a leading dot allows subdomains
+	sender.AllowedHosts = []string{".example.com"}
+	s.NoError(sender.checkEndpoint(&url.URL{Scheme: "https", Host: "push.example.com"}))
+	s.Error(sender.checkEndpoint(&url.URL{Scheme: "https", Host: "example.com.evil"}))
+}
@@ -0,0 +1,217 @@
+package loyalty
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// DefaultClaimTimeout is how long a claim lasts for activities without a deadline.
+const DefaultClaimTimeout = time.Minute
+
+// Outbox remembers which notifications have been delivered, by idempotency key, so a retried activity doesn't send a
+// message twice. The router records each channel separately, so a retry only resends the channels that failed.
+//
+// An attempt claims a message before sending it, so an attempt that overlaps one that timed out, or that follows one
+// that sent the message but failed before recording it, doesn't send it again while the claim lasts. A message whose
+// claim expired without being delivered or released is sent again, with the same key, for the provider to
+// deduplicate.
+type Outbox interface {
+	// Delivered reports whether the message with this key has been delivered.
+	Delivered(ctx context.Context, key string) (bool, error)
+	// Claim records that an attempt is about to send the message with this key, until the given time. It returns
+	// false if another attempt holds a claim that hasn't expired.
+	Claim(ctx context.Context, key string, until time.Time) (bool, error)
+	// Release drops the claim on a message that wasn't sent, so a retry can send it straight away.
+	Release(ctx context.Context, key string) error
+	// MarkDelivered records that the message with this key has been delivered, and drops its claim.
+	MarkDelivered(ctx context.Context, key string) error
+}
+
+// ErrMessageClaimed is returned by deliverOnce when another attempt is delivering the message. It's retried.
+var ErrMessageClaimed = errors.New("message is being delivered by another attempt")
+
+// FileOutbox is an Outbox that keeps one small file per delivered message in Dir, and one per claimed message beside
+// it. It's meant for local use; workers that share it must share the directory.
+type FileOutbox struct {
+	Dir string
+}
+
+type outboxRecord struct {
+	Key          string
+	DeliveredAt  time.Time `json:",omitempty"`
+	ClaimedUntil time.Time `json:",omitempty"`
+}
+
+func (o *FileOutbox) Delivered(_ context.Context, key string) (bool, error) {
+	_, err := os.Stat(o.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Claim creates the claim file exclusively, so only one attempt gets it. An expired claim is replaced; attempts of
+// one activity only overlap once an attempt has timed out, so replacing it doesn't race with another replacement.
+func (o *FileOutbox) Claim(ctx context.Context, key string, until time.Time) (bool, error) {
+	data, err := json.Marshal(outboxRecord{Key: key, ClaimedUntil: until.UTC()})
+	if err != nil {
+		return false, err
+	}
+	err = os.MkdirAll(o.Dir, 0o755)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(o.claimPath(key), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		existing, err := os.ReadFile(o.claimPath(key))
+		if errors.Is(err, os.ErrNotExist) {
+			// delivered or released since; check again
+			return o.Claim(ctx, key, until)
+		} else if err != nil {
+			return false, err
+		}
+		var claim outboxRecord
+		err = json.Unmarshal(existing, &claim)
+		if err == nil && time.Now().Before(claim.ClaimedUntil) {
+			return false, nil
+		}
+		// expired, or left half-written by a crash
+		return true, o.write(o.claimPath(key), data)
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err == nil, err
+}
+
+func (o *FileOutbox) Release(_ context.Context, key string) error {
+	err := os.Remove(o.claimPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (o *FileOutbox) MarkDelivered(ctx context.Context, key string) error {
+	data, err := json.Marshal(outboxRecord{Key: key, DeliveredAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(o.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	err = o.write(o.path(key), data)
+	if err != nil {
+		return err
+	}
+	return o.Release(ctx, key)
+}
+
+func (o *FileOutbox) write(path string, data []byte) error {
+
+	// write then rename, so a crash never leaves a partial record behind
+	f, err := os.CreateTemp(o.Dir, ".pending-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// path hashes the key, which may contain characters that aren't valid in file names.
+func (o *FileOutbox) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(o.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (o *FileOutbox) claimPath(key string) string {
+	return o.path(key) + ".claim"
+}
+
+// channelKey is the idempotency key for one channel's copy of a notification.
+func channelKey(key string, channel Channel) string {
+	return key + "/" + string(channel)
+}
+
+// deliverOnce runs send unless the outbox says the message with this key was already delivered, or another attempt
+// has claimed it, then records it. The claim lasts until this attempt's deadline. Messages without a key, or without
+// an outbox to check, are always sent.
+func (a *Activities) deliverOnce(ctx context.Context, key string, send func() error) error {
+	logger := activity.GetLogger(ctx)
+	if a.Outbox == nil || key == "" {
+		return send()
+	}
+
+	delivered, err := a.Outbox.Delivered(ctx, key)
+	if err != nil {
+		return err
+	}
+	if delivered {
+		logger.Info("Message already delivered; not sending again.", "IdempotencyKey", key)
+		return nil
+	}
+
+	until := activity.GetInfo(ctx).Deadline
+	if until.IsZero() {
+		until = time.Now().Add(DefaultClaimTimeout)
+	}
+	claimed, err := a.Outbox.Claim(ctx, key, until)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("%w: '%v'", ErrMessageClaimed, key)
+	}
+	// another attempt may have delivered it and dropped its claim since we checked
+	delivered, err = a.Outbox.Delivered(ctx, key)
+	if err != nil || delivered {
+		a.releaseClaim(ctx, key)
+		return err
+	}
+
+	err = send()
+	if err != nil {
+		// The provider reported the send failed, so let a retry try again straight away. If it went out anyway, the
+		// retry sends the same key for the provider to deduplicate on.
+		a.releaseClaim(ctx, key)
+		return err
+	}
+	// The message is out, so failing now would only cause a duplicate. Record it even if this attempt's deadline has
+	// just passed, so a retry skips it. If it can't be recorded, the claim still holds off retries until it expires.
+	err = a.Outbox.MarkDelivered(context.Background(), key)
+	if err != nil {
+		logger.Warn("Unable to record delivered message in outbox.", "IdempotencyKey", key, "Error", err)
+	}
+	return nil
+}
+
+// releaseClaim drops this attempt's claim, even if its deadline has just passed. A claim that can't be released only
+// delays retries until it expires.
+func (a *Activities) releaseClaim(ctx context.Context, key string) {
+	err := a.Outbox.Release(context.Background(), key)
+	if err != nil {
+		activity.GetLogger(ctx).Warn("Unable to release claim in outbox.", "IdempotencyKey", key, "Error", err)
+	}
+}
@@ -0,0 +1,213 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *UnitTestSuite) Test_FileOutbox() {
+	dir := s.T().TempDir()
+	outbox := &FileOutbox{Dir: dir}
+	key := "customer-123/run-1/1/welcome/email"
+
+	delivered, err := outbox.Delivered(context.Background(), key)
+	s.NoError(err)
+	s.False(delivered)
+
+	s.NoError(outbox.MarkDelivered(context.Background(), key))
+	s.NoError(outbox.MarkDelivered(context.Background(), key))
+
+	// another worker sharing the directory sees it too
+	delivered, err = (&FileOutbox{Dir: dir}).Delivered(context.Background(), key)
+	s.NoError(err)
+	s.True(delivered)
+
+	delivered, err = outbox.Delivered(context.Background(), "customer-123/run-1/2/welcome/email")
+	s.NoError(err)
+	s.False(delivered)
+}
+
+func (s *UnitTestSuite) Test_FileOutboxClaims() {
+	outbox := &FileOutbox{Dir: s.T().TempDir()}
+	key := "customer-123/run-1/1/welcome/email"
+	later := time.Now().Add(time.Hour)
+
+	claimed, err := outbox.Claim(context.Background(), key, later)
+	s.NoError(err)
+	s.True(claimed)
+
+	// held until it expires, is released or the message is delivered
+	claimed, err = outbox.Claim(context.Background(), key, later)
+	s.NoError(err)
+	s.False(claimed)
+
+	s.NoError(outbox.Release(context.Background(), key))
+	s.NoError(outbox.Release(context.Background(), key))
+	claimed, err = outbox.Claim(context.Background(), key, time.Now().Add(-time.Second))
+	s.NoError(err)
+	s.True(claimed)
+
+	// an expired claim can be taken over
+	claimed, err = outbox.Claim(context.Background(), key, later)
+	s.NoError(err)
+	s.True(claimed)
+
+	s.NoError(outbox.MarkDelivered(context.Background(), key))
+	claimed, err = outbox.Claim(context.Background(), key, later)
+	s.NoError(err)
+	s.True(claimed)
+}
+
+// unrecordedOutbox loses every delivery record, as if each attempt failed just after sending.
+type unrecordedOutbox struct {
+	*FileOutbox
+}
+
+func (o unrecordedOutbox) MarkDelivered(context.Context, string) error {
+	return errors.New("worker stopped")
+}
+
+func (s *UnitTestSuite) Test_SendEmailClaimsBeforeSending() {
+	env := s.NewTestActivityEnvironment()
+
+	var sent []MailMessage
+	a := &Activities{
+		EmailFrom: "loyalty@example.com",
+		Mailer: mailerFunc(func(_ context.Context, message MailMessage) error {
+			sent = append(sent, message)
+			return nil
+		}),
+		Outbox: unrecordedOutbox{&FileOutbox{Dir: s.T().TempDir()}},
+	}
+	env.RegisterActivity(a)
+
+	email := welcomeEmail("Member")
+	email.To = "customer@example.com"
+	email.IdempotencyKey = "customer-123/run-1/1/welcome"
+
+	// the first attempt sends, but never records the delivery
+	_, err := env.ExecuteActivity(a.SendEmail, email)
+	s.NoError(err)
+
+	// so its claim keeps the retry from sending again
+	_, err = env.ExecuteActivity(a.SendEmail, email)
+	s.ErrorContains(err, ErrMessageClaimed.Error())
+	s.Len(sent, 1)
+	s.Equal(email.IdempotencyKey, sent[0].ID)
+}
+
+func (s *UnitTestSuite) Test_SendEmailDeduplicates() {
+	env := s.NewTestActivityEnvironment()
+
+	var sent []MailMessage
+	a := &Activities{
+		EmailFrom: "loyalty@example.com",
+		Mailer: mailerFunc(func(_ context.Context, message MailMessage) error {
+			sent = append(sent, message)
+			return nil
+		}),
+		Outbox: &FileOutbox{Dir: s.T().TempDir()},
+	}
+	env.RegisterActivity(a)
+
+	email := welcomeEmail("Member")
+	email.To = "customer@example.com"
+	email.IdempotencyKey = "customer-123/run-1/1/welcome"
+	for i := 0; i < 2; i++ {
+		_, err := env.ExecuteActivity(a.SendEmail, email)
+		s.NoError(err)
+	}
+	s.Len(sent, 1)
+	s.Equal(email.IdempotencyKey, sent[0].ID)
+
+	// without a key there's nothing to deduplicate on
+	email.IdempotencyKey = ""
+	_, err := env.ExecuteActivity(a.SendEmail, email)
+	s.NoError(err)
+	s.Len(sent, 2)
+}
+
+func (s *UnitTestSuite) Test_SendNotificationRetriesOnlyFailedChannels() {
+	env := s.NewTestActivityEnvironment()
+
+	var mail []MailMessage
+	var sms []SMSMessage
+	smsErr := errors.New("gateway timeout")
+	a := &Activities{
+		EmailFrom: "loyalty@example.com",
+		Mailer: mailerFunc(func(_ context.Context, message MailMessage) error {
+			mail = append(mail, message)
+			return nil
+		}),
+		SMSSender: smsSenderFunc(func(_ context.Context, message SMSMessage) error {
+			if smsErr != nil {
+				return smsErr
+			}
+			sms = append(sms, message)
+			return nil
+		}),
+		Outbox: &FileOutbox{Dir: s.T().TempDir()},
+	}
+	env.RegisterActivity(a)
+
+	notification := Notification{
+		CustomerID: "123",
+		Contact: ContactProfile{
+			Email:    "customer@example.com",
+			Phone:    "+14155550100",
+			Channels: []Channel{ChannelEmail, ChannelSMS},
+		},
+		Template:       TemplateCancelAccount,
+		IdempotencyKey: "customer-123/run-1/1/cancelAccount",
+	}
+
+	// with an outbox, a partial failure is retried
+	_, err := env.ExecuteActivity(a.SendNotification, notification)
+	s.Error(err)
+	s.Len(mail, 1)
+	s.Empty(sms)
+
+	// and the retry only sends what's missing
+	smsErr = nil
+	_, err = env.ExecuteActivity(a.SendNotification, notification)
+	s.NoError(err)
+	s.Len(mail, 1)
+	s.Equal([]SMSMessage{{
+		ID:   "customer-123/run-1/1/cancelAccount/sms",
+		To:   "+14155550100",
+		Body: "Sorry to see you go!",
+	}}, sms)
+}
+
+func (s *UnitTestSuite) Test_NotificationKeys() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	var keys []string
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			keys = append(keys, args.Get(1).(Notification).IdempotencyKey)
+		}).
+		Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*1)
+
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.Equal([]string{
+		"default-test-workflow-id/default-test-run-id/1/welcome",
+		"default-test-workflow-id/default-test-run-id/2/cancelAccount",
+	}, keys)
+}
@@ -0,0 +1,187 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Program is a versioned definition of the loyalty program's rules. Every customer workflow carries the Program it
+// started with, including across continue-as-new, so publishing a new program never changes the behavior of running
+// workflows.
+type Program struct {
+	Version      int            `yaml:"version"`
+	StatusLevels []*StatusLevel `yaml:"statusLevels"`
+	// QualificationPeriod is how long qualifying points count toward status before they reset.
+	QualificationPeriod time.Duration `yaml:"qualificationPeriod"`
+	// DemotionSoftLanding limits requalification to dropping a customer at most one status level per period.
+	DemotionSoftLanding bool `yaml:"demotionSoftLanding"`
+	// RedemptionsAffectTier controls whether spending points also deducts qualifying points, and so can lower a
+	// customer's status. By default, redemptions only reduce the redeemable balance.
+	RedemptionsAffectTier bool             `yaml:"redemptionsAffectTier"`
+	PointsExpiration      ExpirationPolicy `yaml:"pointsExpiration"`
+	// GuestInvitationTTL is how long an invited guest has to accept before their slot is released. Zero means
+	// guests are enrolled as soon as they're invited, without needing to accept.
+	GuestInvitationTTL time.Duration `yaml:"guestInvitationTTL"`
+	// GuestsAsChildWorkflows starts new guests' workflows as abandoned children of their host's workflow, rather
+	// than from an activity, so the relationship shows up in Temporal.
+	GuestsAsChildWorkflows bool `yaml:"guestsAsChildWorkflows"`
+	// DigestQuietPeriod batches low-priority notifications, such as status changes, into one digest that's sent
+	// once none have been added for this long. Zero sends every notification straight away.
+	DigestQuietPeriod time.Duration `yaml:"digestQuietPeriod"`
+	// DigestMaxDelay is the longest the first notification in a digest waits, however often more arrive. Zero means
+	// DefaultDigestMaxDelay.
+	DigestMaxDelay time.Duration `yaml:"digestMaxDelay"`
+	// CancellationGracePeriod keeps a canceled account closed-pending for this long, during which it can be
+	// reactivated with everything intact. Zero closes accounts as soon as they're canceled.
+	CancellationGracePeriod time.Duration `yaml:"cancellationGracePeriod"`
+	// Reopen is what a closed account gets back when the customer rejoins; see ReopenAccount.
+	Reopen RestorePolicy `yaml:"reopen"`
+	// Rewards is the catalog customers redeem points for.
+	Rewards []*Reward `yaml:"rewards"`
+}
+
+// DefaultProgram returns version 1 of the program, used by any workflow started without one. Running workflows may
+// depend on it, so it must never change; publish a new version instead.
+func DefaultProgram() *Program {
+	return &Program{
+		Version: 1,
+		StatusLevels: []*StatusLevel{
+			newStatusLevel(0, "Member", 0, map[Benefit]int{BenefitGuests: 0}),
+			newStatusLevel(1, "Bronze", 500, map[Benefit]int{BenefitGuests: 1}),
+			newStatusLevel(2, "Silver", 1000, map[Benefit]int{BenefitGuests: 2}),
+			newStatusLevel(3, "Gold", 2000, map[Benefit]int{BenefitGuests: 5}),
+			newStatusLevel(4, "Platinum", 5000, map[Benefit]int{BenefitGuests: 10}),
+		},
+		QualificationPeriod: 365 * 24 * time.Hour,
+		DemotionSoftLanding: true,
+		Rewards: []*Reward{
+			newReward("coffee", "Free Coffee", 250),
+			newReward("lounge-pass", "Lounge Day Pass", 1500),
+			newReward("upgrade", "Seat Upgrade", 3000),
+		},
+	}
+}
+
+// LoadProgram reads and validates a program definition from a YAML file.
+func LoadProgram(path string) (*Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read program file '%v': %w", path, err)
+	}
+	return ParseProgram(data)
+}
+
+// ParseProgram decodes and validates a YAML program definition. Status levels are listed lowest first; their
+// ordinals come from their position.
+func ParseProgram(data []byte) (*Program, error) {
+	var program Program
+	err := yaml.Unmarshal(data, &program)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse program: %w", err)
+	}
+	for i, level := range program.StatusLevels {
+		if level != nil {
+			level.Ordinal = i
+		}
+	}
+
+	err = program.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &program, nil
+}
+
+// Validate checks that the program is internally consistent.
+func (p *Program) Validate() error {
+	var errs []error
+	if p.Version <= 0 {
+		errs = append(errs, fmt.Errorf("version must be positive, got %v", p.Version))
+	}
+	if p.QualificationPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("qualification period must be positive, got %v", p.QualificationPeriod))
+	}
+	if len(p.StatusLevels) == 0 {
+		errs = append(errs, errors.New("at least one status level is required"))
+	}
+
+	names := make(map[string]bool)
+	for i, level := range p.StatusLevels {
+		if level == nil {
+			errs = append(errs, fmt.Errorf("status level %v is empty", i))
+			continue
+		}
+		if level.Ordinal != i {
+			errs = append(errs, fmt.Errorf("status level '%v' has ordinal %v but is at position %v", level.Name, level.Ordinal, i))
+		}
+		if level.Name == "" {
+			errs = append(errs, fmt.Errorf("status level %v has no name", i))
+		} else if names[level.Name] {
+			errs = append(errs, fmt.Errorf("status level name '%v' is used more than once", level.Name))
+		}
+		names[level.Name] = true
+
+		if i == 0 && level.MinimumPoints != 0 {
+			errs = append(errs, fmt.Errorf("lowest status level '%v' must have a minimum of 0 points", level.Name))
+		}
+		if i > 0 && p.StatusLevels[i-1] != nil && level.MinimumPoints <= p.StatusLevels[i-1].MinimumPoints {
+			errs = append(errs, fmt.Errorf("status level '%v' must require more points than '%v'",
+				level.Name, p.StatusLevels[i-1].Name))
+		}
+		if level.EarningMultiplier < 0 {
+			errs = append(errs, fmt.Errorf("status level '%v' has a negative earning multiplier", level.Name))
+		}
+		for benefit, allowed := range level.Benefits {
+			if allowed < 0 {
+				errs = append(errs, fmt.Errorf("status level '%v' allows a negative amount of '%v'", level.Name, benefit))
+			}
+		}
+	}
+
+	rewardIDs := make(map[string]bool)
+	for i, reward := range p.Rewards {
+		if reward == nil {
+			errs = append(errs, fmt.Errorf("reward %v is empty", i))
+			continue
+		}
+		if reward.ID == "" {
+			errs = append(errs, fmt.Errorf("reward %v has no ID", i))
+		} else if rewardIDs[reward.ID] {
+			errs = append(errs, fmt.Errorf("reward ID '%v' is used more than once", reward.ID))
+		}
+		rewardIDs[reward.ID] = true
+
+		if reward.Name == "" {
+			errs = append(errs, fmt.Errorf("reward '%v' has no name", reward.ID))
+		}
+		if reward.Cost <= 0 {
+			errs = append(errs, fmt.Errorf("reward '%v' must cost a positive number of points", reward.ID))
+		}
+	}
+
+	expiration := p.PointsExpiration
+	if expiration.InactivityPeriod < 0 || expiration.BatchLifetime < 0 || expiration.WarningLead < 0 {
+		errs = append(errs, errors.New("points expiration durations can't be negative"))
+	}
+	if p.GuestInvitationTTL < 0 {
+		errs = append(errs, errors.New("guest invitation TTL can't be negative"))
+	}
+	if p.DigestQuietPeriod < 0 {
+		errs = append(errs, errors.New("digest quiet period can't be negative"))
+	}
+	if p.DigestMaxDelay < 0 {
+		errs = append(errs, errors.New("digest max delay can't be negative"))
+	}
+	if p.CancellationGracePeriod < 0 {
+		errs = append(errs, errors.New("cancellation grace period can't be negative"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid program version %v: %w", p.Version, errors.Join(errs...))
+	}
+	return nil
+}
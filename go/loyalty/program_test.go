@@ -0,0 +1,96 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (s *UnitTestSuite) Test_LoadProgram() {
+	program, err := LoadProgram("program.yaml")
+	s.NoError(err)
+
+	s.Equal(2, program.Version)
+	s.Equal(time.Hour*24*365, program.QualificationPeriod)
+	s.Equal(time.Hour*24*30, program.PointsExpiration.WarningLead)
+	s.Equal(time.Hour*24*7, program.GuestInvitationTTL)
+	s.True(program.GuestsAsChildWorkflows)
+	s.Equal(time.Hour, program.DigestQuietPeriod)
+	s.Equal(time.Hour*24, program.DigestMaxDelay)
+	s.Equal(time.Hour*24*30, program.CancellationGracePeriod)
+	s.Equal(RestorePolicy{Points: true}, program.Reopen)
+	s.Len(program.StatusLevels, 5)
+	for i, level := range program.StatusLevels {
+		s.Equal(i, level.Ordinal)
+	}
+	s.Equal("Platinum", program.StatusLevelForPoints(10_000).Name)
+	s.Len(program.Rewards, 3)
+	s.Equal(&Reward{ID: "lounge-pass", Name: "Lounge Day Pass", Cost: 1500}, program.RewardForID("lounge-pass"))
+	s.Nil(program.RewardForID("yacht"))
+}
+
+func (s *UnitTestSuite) Test_ProgramRewards() {
+	// each program redeems from its own catalog
+	program := DefaultProgram()
+	program.Rewards = []*Reward{newReward("tea", "Free Tea", 200)}
+	s.Equal("Free Tea", program.RewardForID("tea").Name)
+	s.Nil(program.RewardForID("coffee"))
+
+	// workflows pinned to a program from before catalogs keep the original rewards
+	program.Rewards = nil
+	s.Equal(DefaultProgram().RewardForID("coffee"), program.RewardForID("coffee"))
+	s.Nil(program.RewardForID("tea"))
+}
+
+func (s *UnitTestSuite) Test_ParseProgramValidation() {
+	_, err := ParseProgram([]byte(`
+version: 3
+qualificationPeriod: 8760h
+statusLevels:
+  - name: Member
+    minimumPoints: 0
+  - name: Gold
+    minimumPoints: 2000
+  - name: Silver
+    minimumPoints: 1000
+  - name: Gold
+    minimumPoints: 5000
+`))
+	s.ErrorContains(err, "'Silver' must require more points than 'Gold'")
+	s.ErrorContains(err, "'Gold' is used more than once")
+
+	_, err = ParseProgram([]byte(`
+version: 0
+statusLevels:
+  - name: Member
+    minimumPoints: 100
+`))
+	s.ErrorContains(err, "version must be positive")
+	s.ErrorContains(err, "qualification period must be positive")
+	s.ErrorContains(err, "must have a minimum of 0 points")
+
+	_, err = ParseProgram([]byte(`
+version: 3
+qualificationPeriod: 8760h
+statusLevels:
+  - name: Member
+    minimumPoints: 0
+rewards:
+  - id: coffee
+    name: Free Coffee
+    cost: 250
+  - id: coffee
+    name: Free Tea
+    cost: 0
+`))
+	s.ErrorContains(err, "reward ID 'coffee' is used more than once")
+	s.ErrorContains(err, "reward 'coffee' must cost a positive number of points")
+
+	s.NoError(DefaultProgram().Validate())
+}
+
+func (s *UnitTestSuite) Test_StatusLevelLegacyGuestsAllowed() {
+	var level StatusLevel
+	err := json.Unmarshal([]byte(`{"Ordinal":2,"Name":"Silver","MinimumPoints":1000,"GuestsAllowed":2}`), &level)
+	s.NoError(err)
+	s.Equal(*newStatusLevel(2, "Silver", 1000, map[Benefit]int{BenefitGuests: 2}), level)
+}
@@ -0,0 +1,144 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+)
+
+// ErrAccountNotClosed is returned by ReopenAccount for customers whose workflow is still running. Accounts that are
+// only closed-pending are reactivated with SignalReactivateAccount instead.
+var ErrAccountNotClosed = errors.New("account is not closed")
+
+// RestorePolicy is what a reopened account gets back from the customer's previous account. The customer's name,
+// segments and contact profile are always kept.
+type RestorePolicy struct {
+	// Points restores the redeemable balance. Under the same expiration policy it keeps when it was earned, so it
+	// still expires on time; under a different one it's treated as earned when the account reopens.
+	Points bool `yaml:"points"`
+	// Status restores the status level and the qualifying points of the qualification period it was in. If that
+	// period has since ended, the customer is requalified as soon as the account reopens.
+	Status bool `yaml:"status"`
+}
+
+func (p RestorePolicy) restoresAnything() bool {
+	return p.Points || p.Status
+}
+
+// ReopenRequest describes how to reopen a closed customer account.
+type ReopenRequest struct {
+	CustomerID string
+	// Program pins the reopened account to a program. The previous account's program is used if nil.
+	Program *Program
+	// Policy overrides the program's Reopen policy.
+	Policy *RestorePolicy
+}
+
+// ReopenAccount starts a new run of a closed customer's workflow, under the same Workflow ID, restoring what the
+// policy allows from the previous run's result. Guest invitations never reopen an account; this is the only way back
+// for a customer who canceled.
+func ReopenAccount(ctx context.Context, c client.Client, request ReopenRequest) (client.WorkflowRun, error) {
+	workflowID := CustomerWorkflowID(request.CustomerID)
+
+	existing, err := c.DescribeWorkflowExecution(ctx, workflowID, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe workflow for customer '%v': %w", request.CustomerID, err)
+	}
+	execution := existing.GetWorkflowExecutionInfo()
+	status := execution.GetStatus()
+	if status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return nil, fmt.Errorf("%w: customer '%v'", ErrAccountNotClosed, request.CustomerID)
+	}
+
+	// Only runs that closed the account normally have a final state to restore from.
+	var previous CustomerInfo
+	if status == enums.WORKFLOW_EXECUTION_STATUS_COMPLETED {
+		err = c.GetWorkflow(ctx, workflowID, execution.GetExecution().GetRunId()).Get(ctx, &previous)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get final state for customer '%v': %w", request.CustomerID, err)
+		}
+	}
+
+	customer, err := reopenedCustomer(previous, request, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                    workflowID,
+		TaskQueue:             TaskQueue,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+	run, err := c.ExecuteWorkflow(ctx, workflowOptions, CustomerLoyaltyWorkflow, customer, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reopen account for customer '%v': %w", request.CustomerID, err)
+	}
+	return run, nil
+}
+
+// statusName returns the name of the status the customer held, or "" if it isn't in their program.
+func (c CustomerInfo) statusName() string {
+	program := c.Program
+	if program == nil {
+		program = DefaultProgram()
+	}
+	if c.StatusOrdinal < 0 || c.StatusOrdinal >= len(program.StatusLevels) {
+		return ""
+	}
+	return program.StatusLevels[c.StatusOrdinal].Name
+}
+
+// reopenedCustomer builds the new run's starting state from the previous run's final state. previous is empty if
+// that run didn't leave one, in which case there's nothing to restore, and a policy that asks for anything is an
+// error.
+func reopenedCustomer(previous CustomerInfo, request ReopenRequest, now time.Time) (CustomerInfo, error) {
+	program := request.Program
+	if program == nil {
+		program = previous.Program
+	}
+	if program == nil {
+		program = DefaultProgram()
+	}
+	policy := program.Reopen
+	if request.Policy != nil {
+		policy = *request.Policy
+	}
+
+	if previous.CustomerID == "" && policy.restoresAnything() {
+		return CustomerInfo{}, fmt.Errorf("no final state to restore for customer '%v'", request.CustomerID)
+	}
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    request.CustomerID,
+		Name:          previous.Name,
+		Segments:      previous.Segments,
+		Contact:       previous.Contact,
+		AccountActive: true,
+	}
+	if policy.Points {
+		customer.LoyaltyPoints = previous.LoyaltyPoints
+		if previous.Program != nil && previous.Program.PointsExpiration == program.PointsExpiration {
+			customer.PointBatches = previous.PointBatches
+			customer.LastEarnedAt = previous.LastEarnedAt
+		}
+		customer.reconcileBatches(now)
+	}
+	// status is restored by name, and only if the new program has the same level
+	var status *StatusLevel
+	if name := previous.statusName(); policy.Status && name != "" {
+		status = program.statusLevelFor(MinimumStatus{Status: name})
+	}
+	if status != nil {
+		customer.StatusOrdinal = status.Ordinal
+		customer.QualifyingPoints = previous.QualifyingPoints
+		customer.QualificationPeriodStart = previous.QualificationPeriodStart
+	} else {
+		// start a new period, so restored points don't qualify the customer for status the way a legacy balance would
+		customer.QualificationPeriodStart = now
+	}
+	return customer, nil
+}
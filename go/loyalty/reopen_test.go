@@ -0,0 +1,220 @@
+package loyalty
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+)
+
+func (s *UnitTestSuite) Test_WorkflowResultIsFinalState() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+	env.OnActivity("SendNotification", mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[2].MinimumPoints)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, CancelAccountRequest{Reason: "moving away"})
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	var final CustomerInfo
+	s.NoError(env.GetWorkflowResult(&final))
+	s.Equal("123", final.CustomerID)
+	s.Equal(statusLevels[2].MinimumPoints, final.LoyaltyPoints)
+	s.Equal(2, final.StatusOrdinal)
+	s.Equal(AccountStateClosed, final.Account.State)
+	s.Equal("moving away", final.Account.Reason)
+	s.False(final.AccountActive)
+}
+
+func (s *UnitTestSuite) Test_ReopenedCustomer() {
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	periodStart := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	program := DefaultProgram()
+	previous := CustomerInfo{
+		Program:                  program,
+		CustomerID:               "123",
+		Name:                     "Customer",
+		Contact:                  ContactProfile{Email: "customer@example.com"},
+		LoyaltyPoints:            1200,
+		PointBatches:             []PointsBatch{{Points: 1200, EarnedAt: periodStart}},
+		LastEarnedAt:             periodStart,
+		QualifyingPoints:         2500,
+		QualificationPeriodStart: periodStart,
+		StatusOrdinal:            3,
+		Guests:                   []string{"guest"},
+		Account:                  AccountStatus{State: AccountStateClosed, Reason: "moving away"},
+	}
+	fresh := CustomerInfo{
+		Program:       program,
+		CustomerID:    "123",
+		Name:          "Customer",
+		Contact:       ContactProfile{Email: "customer@example.com"},
+		AccountActive: true,
+	}
+
+	// nothing earned comes back by default
+	customer, err := reopenedCustomer(previous, ReopenRequest{CustomerID: "123"}, now)
+	s.NoError(err)
+	expected := fresh
+	expected.QualificationPeriodStart = now
+	s.Equal(expected, customer)
+
+	customer, err = reopenedCustomer(previous, ReopenRequest{CustomerID: "123", Policy: &RestorePolicy{Points: true}}, now)
+	s.NoError(err)
+	expected.LoyaltyPoints = 1200
+	expected.PointBatches = previous.PointBatches
+	expected.LastEarnedAt = periodStart
+	s.Equal(expected, customer)
+
+	customer, err = reopenedCustomer(previous, ReopenRequest{CustomerID: "123", Policy: &RestorePolicy{Status: true}}, now)
+	s.NoError(err)
+	expected = fresh
+	expected.StatusOrdinal = 3
+	expected.QualifyingPoints = 2500
+	expected.QualificationPeriodStart = periodStart
+	s.Equal(expected, customer)
+
+	// the policy can come from the program, and status is restored by name
+	renumbered := &Program{
+		Version: 9,
+		StatusLevels: []*StatusLevel{
+			newStatusLevel(0, "Basic", 0, nil),
+			newStatusLevel(1, "Gold", 1000, nil),
+			newStatusLevel(2, "Diamond", 3000, nil),
+		},
+		QualificationPeriod: time.Hour * 24 * 365,
+		Reopen:              RestorePolicy{Status: true},
+	}
+	customer, err = reopenedCustomer(previous, ReopenRequest{CustomerID: "123", Program: renumbered}, now)
+	s.NoError(err)
+	s.Equal(renumbered, customer.Program)
+	s.Equal(1, customer.StatusOrdinal)
+	s.Equal(2500, customer.QualifyingPoints)
+
+	// a program without the customer's level doesn't restore status at all
+	smaller := &Program{
+		Version: 9,
+		StatusLevels: []*StatusLevel{
+			newStatusLevel(0, "Basic", 0, nil),
+			newStatusLevel(1, "Elite", 1000, nil),
+		},
+		QualificationPeriod: time.Hour * 24 * 365,
+		Reopen:              RestorePolicy{Status: true},
+	}
+	customer, err = reopenedCustomer(previous, ReopenRequest{CustomerID: "123", Program: smaller}, now)
+	s.NoError(err)
+	s.Equal(0, customer.StatusOrdinal)
+	s.Equal(0, customer.QualifyingPoints)
+	s.Equal(now, customer.QualificationPeriodStart)
+
+	// batches are rebuilt under a program with a different expiration policy, so the balance is earned at reopening
+	expiring := DefaultProgram()
+	expiring.Version = 10
+	expiring.PointsExpiration = ExpirationPolicy{BatchLifetime: time.Hour * 24 * 365, WarningLead: time.Hour * 24 * 30}
+	previous.PointBatches = []PointsBatch{{Points: 1200, EarnedAt: periodStart, Warned: true}}
+	customer, err = reopenedCustomer(previous, ReopenRequest{
+		CustomerID: "123",
+		Program:    expiring,
+		Policy:     &RestorePolicy{Points: true},
+	}, now)
+	s.NoError(err)
+	s.Equal(1200, customer.LoyaltyPoints)
+	s.Equal([]PointsBatch{{Points: 1200, EarnedAt: now}}, customer.PointBatches)
+	s.Equal(now, customer.LastEarnedAt)
+
+	// and dropped under one without expiration
+	previous.Program = expiring
+	customer, err = reopenedCustomer(previous, ReopenRequest{
+		CustomerID: "123",
+		Program:    program,
+		Policy:     &RestorePolicy{Points: true},
+	}, now)
+	s.NoError(err)
+	s.Equal(1200, customer.LoyaltyPoints)
+	s.Empty(customer.PointBatches)
+	s.True(customer.LastEarnedAt.IsZero())
+
+	// the same policy keeps the original batches
+	customer, err = reopenedCustomer(previous, ReopenRequest{CustomerID: "123", Policy: &RestorePolicy{Points: true}}, now)
+	s.NoError(err)
+	s.Equal(previous.PointBatches, customer.PointBatches)
+	s.Equal(periodStart, customer.LastEarnedAt)
+
+	// runs that didn't close normally leave nothing to restore
+	_, err = reopenedCustomer(CustomerInfo{}, ReopenRequest{CustomerID: "123", Policy: &RestorePolicy{Points: true}}, now)
+	s.ErrorContains(err, "no final state to restore for customer '123'")
+	customer, err = reopenedCustomer(CustomerInfo{}, ReopenRequest{CustomerID: "123"}, now)
+	s.NoError(err)
+	s.Equal(DefaultProgram(), customer.Program)
+	s.True(customer.AccountActive)
+}
+
+func (s *UnitTestSuite) Test_ReopenAccount() {
+	describe := func(status enums.WorkflowExecutionStatus) *workflowservice.DescribeWorkflowExecutionResponse {
+		return &workflowservice.DescribeWorkflowExecutionResponse{
+			WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{
+				Execution: &commonpb.WorkflowExecution{RunId: "run-1"},
+				Status:    status,
+			},
+		}
+	}
+	previous := CustomerInfo{
+		Program:       DefaultProgram(),
+		CustomerID:    "closed",
+		LoyaltyPoints: 300,
+		Account:       AccountStatus{State: AccountStateClosed},
+	}
+	previousRun := &mocks.WorkflowRun{}
+	previousRun.On("Get", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			*args.Get(1).(*CustomerInfo) = previous
+		}).
+		Return(nil)
+	newRun := &mocks.WorkflowRun{}
+
+	c := &mocks.Client{}
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("open"), "").
+		Return(describe(enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil)
+	c.On("DescribeWorkflowExecution", mock.Anything, CustomerWorkflowID("closed"), "").
+		Return(describe(enums.WORKFLOW_EXECUTION_STATUS_COMPLETED), nil)
+	c.On("GetWorkflow", mock.Anything, CustomerWorkflowID("closed"), "run-1").Return(previousRun)
+	c.On("ExecuteWorkflow", mock.Anything,
+		mock.MatchedBy(func(options client.StartWorkflowOptions) bool {
+			return options.ID == CustomerWorkflowID("closed") &&
+				options.WorkflowIDReusePolicy == enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE
+		}),
+		mock.Anything,
+		mock.MatchedBy(func(customer CustomerInfo) bool {
+			return customer.CustomerID == "closed" && customer.LoyaltyPoints == 300 && customer.AccountActive
+		}),
+		true).
+		Return(newRun, nil)
+
+	_, err := ReopenAccount(context.Background(), c, ReopenRequest{CustomerID: "open"})
+	s.ErrorIs(err, ErrAccountNotClosed)
+
+	run, err := ReopenAccount(context.Background(), c, ReopenRequest{
+		CustomerID: "closed",
+		Policy:     &RestorePolicy{Points: true},
+	})
+	s.NoError(err)
+	s.Equal(newRun, run)
+	c.AssertExpectations(s.T())
+}
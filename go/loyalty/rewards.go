@@ -0,0 +1,44 @@
+package loyalty
+
+import "time"
+
+// Reward is something customers can redeem points for. Each program has its own catalog of them.
+type Reward struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	Cost int    `yaml:"cost"`
+}
+
+func newReward(id string, name string, cost int) *Reward {
+	return &Reward{ID: id, Name: name, Cost: cost}
+}
+
+// RewardForID returns nil if no reward in the program's catalog has the given ID. Programs that predate reward
+// catalogs use the one version 1 shipped with.
+func (p *Program) RewardForID(id string) *Reward {
+	rewards := p.Rewards
+	if rewards == nil {
+		rewards = DefaultProgram().Rewards
+	}
+	for _, reward := range rewards {
+		if reward.ID == id {
+			return reward
+		}
+	}
+	return nil
+}
+
+// RedeemRequest is the payload for SignalRedeemReward and UpdateRedeemReward. Like PointsTransaction, a non-empty ID
+// makes the request idempotent.
+type RedeemRequest struct {
+	ID       string
+	RewardID string
+}
+
+// Redemption is a reward whose points have been reserved but not yet fulfilled.
+type Redemption struct {
+	ID          string
+	RewardID    string
+	Points      int
+	RequestedAt time.Time
+}
@@ -1,25 +1,324 @@
 package loyalty
 
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// MaxRecentTransactions bounds how many transaction IDs a customer workflow remembers for de-duplication.
+const MaxRecentTransactions = 1000
+
+// Namespaces that redemption and benefit IDs are de-duplicated in. Points transactions are de-duplicated per source.
+const (
+	transactionsRedeem  = "redeem"
+	transactionsBenefit = "benefit"
+)
+
+// MaxPointsHistory bounds how many entries a customer workflow keeps in its points history.
+const MaxPointsHistory = 100
+
 type CustomerInfo struct {
-	CustomerID    string
-	LoyaltyPoints int
-	Name          string
-	Guests        []string
-	AccountActive bool
+	// Program is the version of the program rules this customer is pinned to. Workflows started without one use
+	// DefaultProgram.
+	Program *Program
+
+	CustomerID           string
+	LoyaltyPoints        int
+	Name                 string
+	Segments             []string
+	Guests               []string
+	AccountActive        bool
+	RecentTransactionIDs []string
+	PointsHistory        []PointsHistoryEntry
+	PendingRedemptions   []Redemption
+	// FailedTransactions holds why each remembered transaction that failed did, by its entry in RecentTransactionIDs,
+	// so a retry gets the same failure.
+	FailedTransactions map[string]string
+
+	// QualifyingPoints count toward status and are tracked per qualification period, independently of the
+	// redeemable LoyaltyPoints balance.
+	QualifyingPoints         int
+	QualificationPeriodStart time.Time
+	// StatusOrdinal is the status the customer holds. Promotions take effect as soon as enough qualifying points are
+	// earned; demotions only happen at requalification.
+	StatusOrdinal int
+	// Invitations are this customer's pending and accepted guest invitations.
+	Invitations []GuestInvitation
+	// SponsorID is the host whose guest invitation this customer's status came from, if any. SponsoredOrdinal is the
+	// status that host sponsors; requalification never demotes the customer below it.
+	SponsorID        string
+	SponsoredOrdinal int
+	// ReportedToSponsor is the status last sent to the sponsor, so only changes are reported.
+	ReportedToSponsor GuestStatus
+
+	// Campaigns are the promotions this customer has been sent that haven't ended yet.
+	Campaigns []Campaign
+
+	// BenefitsUsed counts benefits consumed in the current qualification period.
+	BenefitsUsed map[Benefit]int
+
+	// PointBatches track when the redeemable balance was earned, for expiration.
+	PointBatches []PointsBatch
+	LastEarnedAt time.Time
+
+	// Contact is how and where the customer is notified.
+	Contact ContactProfile
+
+	// Account is where the account is in its lifecycle; AccountActive is only true while it's active. Customers
+	// started without an Account state are active if AccountActive is set, and closed otherwise. PendingCancellation
+	// is the request a closed-pending account carries out when it closes.
+	Account             AccountStatus
+	PendingCancellation CancelAccountRequest
+
+	// PendingDigest holds low-priority notifications waiting to be sent together at DigestDueAt. DigestStartedAt is
+	// when the first of them was queued.
+	PendingDigest   []Email
+	DigestStartedAt time.Time
+	DigestDueAt     time.Time
+
+	// notificationsSent numbers this run's notifications for their idempotency keys. It isn't carried over by
+	// continue-as-new; the run ID in the key keeps runs apart.
+	notificationsSent int
+	// namespacedTransactions records transaction IDs under their namespace. Runs started before namespacing record
+	// the bare IDs until they continue-as-new.
+	namespacedTransactions bool
+	// annualRequalification is set in runs that only demote at the end of a qualification period. Runs started before
+	// requalification demote as soon as qualifying points drop, until they continue-as-new.
+	annualRequalification bool
 }
 
 type GetStatusResponse struct {
-	StatusLevel   StatusLevel
-	Points        int
-	AccountActive bool
+	ProgramVersion         int
+	StatusLevel            StatusLevel
+	Points                 int
+	QualifyingPoints       int
+	QualificationPeriodEnd time.Time
+	AccountActive          bool
+	Account                AccountStatus
+}
+
+type PointsSource string
+
+const (
+	SourcePurchase   PointsSource = "purchase"
+	SourceAdjustment PointsSource = "adjustment"
+)
+
+// PointsTransaction is the payload for SignalAddPoints. A non-empty ID makes the transaction idempotent: the
+// workflow ignores any transaction whose ID it has recently seen.
+type PointsTransaction struct {
+	ID        string
+	Amount    int
+	Source    PointsSource
+	Reason    string
+	Timestamp time.Time
+	// NonQualifying points are redeemable but don't count toward status, e.g. goodwill credits.
+	NonQualifying bool
+	// RefundOf is the ID of the purchase this transaction refunds, if any.
+	RefundOf string
+}
+
+// PointsHistoryEntry records how a transaction was credited to the customer's account.
+type PointsHistoryEntry struct {
+	TransactionID string
+	Source        PointsSource
+	Reason        string
+	Timestamp     time.Time
+	// Amount is the transaction's amount; PointsCredited is what was actually credited after the status and
+	// campaign multipliers.
+	Amount             int
+	Multiplier         float64
+	CampaignID         string
+	CampaignMultiplier float64
+	PointsCredited     int
+}
+
+// UnmarshalJSON accepts either a full transaction or, for callers that predate transactions, a bare point count.
+func (t *PointsTransaction) UnmarshalJSON(data []byte) error {
+	var amount int
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*t = PointsTransaction{Amount: amount}
+		return nil
+	}
+
+	type transaction PointsTransaction
+	return json.Unmarshal(data, (*transaction)(t))
+}
+
+// credit works out how many points the transaction is worth at the customer's current status and under any campaign
+// running at the transaction's timestamp. Only purchases earn multipliers. A refund takes back points at the
+// multipliers the original purchase earned, as long as it's still in the points history.
+func (c *CustomerInfo) credit(transaction PointsTransaction) PointsHistoryEntry {
+	entry := PointsHistoryEntry{
+		TransactionID:      transaction.ID,
+		Source:             transaction.Source,
+		Reason:             transaction.Reason,
+		Timestamp:          transaction.Timestamp,
+		Amount:             transaction.Amount,
+		Multiplier:         1,
+		CampaignMultiplier: 1,
+	}
+	original := c.purchaseHistory(transaction.RefundOf)
+	if transaction.Source == SourcePurchase && original != nil {
+		entry.Multiplier = original.Multiplier
+		entry.CampaignID = original.CampaignID
+		entry.CampaignMultiplier = original.CampaignMultiplier
+	} else if transaction.Source == SourcePurchase {
+		entry.Multiplier = c.status().Multiplier()
+		if campaign := c.bestCampaign(transaction.Timestamp); campaign != nil {
+			entry.CampaignID = campaign.ID
+			entry.CampaignMultiplier = campaign.Multiplier
+		}
+	}
+
+	entry.PointsCredited = int(math.Round(float64(transaction.Amount) * entry.Multiplier * entry.CampaignMultiplier))
+	return entry
+}
+
+// purchaseHistory returns the history entry for the purchase with the given ID, or nil if it isn't in the history.
+func (c *CustomerInfo) purchaseHistory(transactionID string) *PointsHistoryEntry {
+	if transactionID == "" {
+		return nil
+	}
+	for i := len(c.PointsHistory) - 1; i >= 0; i-- {
+		if entry := &c.PointsHistory[i]; entry.TransactionID == transactionID && entry.Source == SourcePurchase {
+			return entry
+		}
+	}
+	return nil
+}
+
+// recordHistory appends the entry, dropping the oldest once MaxPointsHistory is reached.
+func (c *CustomerInfo) recordHistory(entry PointsHistoryEntry) {
+	c.PointsHistory = append(c.PointsHistory, entry)
+	if len(c.PointsHistory) > MaxPointsHistory {
+		c.PointsHistory = c.PointsHistory[len(c.PointsHistory)-MaxPointsHistory:]
+	}
 }
 
 func (c *CustomerInfo) addGuest(guestID string) {
-	// Add if not there
-	for _, g := range c.Guests {
-		if g == guestID {
+	if !c.hasGuest(guestID) {
+		c.Guests = append(c.Guests, guestID)
+	}
+}
+
+func (c *CustomerInfo) status() *StatusLevel {
+	return c.Program.StatusLevels[c.StatusOrdinal]
+}
+
+// promote raises the customer's status to whatever their qualifying points have earned, if that's higher. Returns
+// true if the status changed.
+func (c *CustomerInfo) promote() bool {
+	earned := c.Program.StatusLevelForPoints(c.QualifyingPoints).Ordinal
+	if earned > c.StatusOrdinal {
+		c.StatusOrdinal = earned
+		return true
+	}
+	return false
+}
+
+// advanceQualificationPeriod starts a new qualification period, with no qualifying points, if the current one has
+// ended by now. Returns true if the period changed.
+func (c *CustomerInfo) advanceQualificationPeriod(now time.Time) bool {
+	if now.Before(c.qualificationPeriodEnd()) {
+		return false
+	}
+	for !now.Before(c.qualificationPeriodEnd()) {
+		c.QualificationPeriodStart = c.qualificationPeriodEnd()
+	}
+	c.QualifyingPoints = 0
+	c.BenefitsUsed = nil
+	return true
+}
+
+func (c *CustomerInfo) qualificationPeriodEnd() time.Time {
+	return c.QualificationPeriodStart.Add(c.Program.QualificationPeriod)
+}
+
+// reservePoints moves the redemption's points out of the spendable balance and holds them until it's fulfilled.
+func (c *CustomerInfo) reservePoints(redemption Redemption) {
+	c.LoyaltyPoints -= redemption.Points
+	c.spendBatches(redemption.Points)
+	if c.Program.RedemptionsAffectTier {
+		c.QualifyingPoints -= redemption.Points
+	}
+	c.PendingRedemptions = append(c.PendingRedemptions, redemption)
+}
+
+func (c *CustomerInfo) redemptionPending(redemptionID string) bool {
+	for _, r := range c.PendingRedemptions {
+		if r.ID == redemptionID {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseRedemption drops the redemption from the pending list, refunding its points if requested.
+func (c *CustomerInfo) releaseRedemption(redemptionID string, refund bool) {
+	for i, r := range c.PendingRedemptions {
+		if r.ID == redemptionID {
+			if refund {
+				c.LoyaltyPoints += r.Points
+				if c.Program.PointsExpiration.enabled() {
+					c.PointBatches = append(c.PointBatches, PointsBatch{Points: r.Points, EarnedAt: r.RequestedAt})
+				}
+				if c.Program.RedemptionsAffectTier {
+					c.QualifyingPoints += r.Points
+				}
+			}
+			c.PendingRedemptions = append(c.PendingRedemptions[:i], c.PendingRedemptions[i+1:]...)
 			return
 		}
 	}
-	c.Guests = append(c.Guests, guestID)
+}
+
+// transactionKey is how a transaction ID is remembered, so the same ID in different namespaces, e.g. a purchase and a
+// redemption, doesn't collide.
+func (c *CustomerInfo) transactionKey(namespace, id string) string {
+	if !c.namespacedTransactions {
+		return id
+	}
+	return namespace + ":" + id
+}
+
+// hasSeenTransaction also matches bare IDs recorded before namespacing, until they age out.
+func (c *CustomerInfo) hasSeenTransaction(namespace, id string) bool {
+	key := c.transactionKey(namespace, id)
+	for _, seen := range c.RecentTransactionIDs {
+		if seen == key || seen == id {
+			return true
+		}
+	}
+	return false
+}
+
+// recordTransaction remembers the ID, dropping the oldest once MaxRecentTransactions is reached.
+func (c *CustomerInfo) recordTransaction(namespace, id string) {
+	c.RecentTransactionIDs = append(c.RecentTransactionIDs, c.transactionKey(namespace, id))
+	if len(c.RecentTransactionIDs) > MaxRecentTransactions {
+		dropped := len(c.RecentTransactionIDs) - MaxRecentTransactions
+		for _, key := range c.RecentTransactionIDs[:dropped] {
+			delete(c.FailedTransactions, key)
+		}
+		c.RecentTransactionIDs = c.RecentTransactionIDs[dropped:]
+	}
+}
+
+// failTransaction remembers that a recorded transaction failed with err.
+func (c *CustomerInfo) failTransaction(namespace, id string, err error) {
+	if c.FailedTransactions == nil {
+		c.FailedTransactions = make(map[string]string)
+	}
+	c.FailedTransactions[c.transactionKey(namespace, id)] = err.Error()
+}
+
+// transactionError returns the error a remembered transaction failed with, or nil if it succeeded.
+func (c *CustomerInfo) transactionError(namespace, id string) error {
+	if reason, ok := c.FailedTransactions[c.transactionKey(namespace, id)]; ok {
+		return errors.New(reason)
+	}
+	return nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 
 	wf "github.com/afitz0/customer-loyalty-workflow/go"
@@ -10,6 +11,20 @@ import (
 )
 
 func main() {
+	programFile := flag.String("program", "", "Path to a program definition file. Uses the built-in program if unset.")
+	reopen := flag.Bool("reopen", false, "Reopen the customer's closed account, restoring what the program's "+
+		"reopen policy allows. Keeps the previous account's program unless -program is set.")
+	flag.Parse()
+
+	program := wf.DefaultProgram()
+	if *programFile != "" {
+		var err error
+		program, err = wf.LoadProgram(*programFile)
+		if err != nil {
+			log.Fatalln("Unable to load program.", err)
+		}
+	}
+
 	logger := wf.NewZapAdapter(wf.NewZapLogger(zapcore.DebugLevel))
 	c, err := client.Dial(client.Options{
 		Logger: logger,
@@ -19,10 +34,25 @@ func main() {
 	}
 	defer c.Close()
 
+	if *reopen {
+		request := wf.ReopenRequest{CustomerID: "123"}
+		if *programFile != "" {
+			request.Program = program
+		}
+		we, err := wf.ReopenAccount(context.Background(), c, request)
+		if err != nil {
+			log.Fatalln("Unable to reopen account.", err)
+		}
+		log.Println("Reopened account.", "WorkflowID", we.GetID(), "RunID", we.GetRunID())
+		return
+	}
+
 	customer := wf.CustomerInfo{
+		Program:       program,
 		CustomerID:    "123",
 		Name:          "Customer",
 		AccountActive: true,
+		Contact:       wf.ContactProfile{Email: "customer@example.com"},
 	}
 	workflowOptions := client.StartWorkflowOptions{
 		ID:        wf.CustomerWorkflowID(customer.CustomerID),
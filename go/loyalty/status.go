@@ -1,37 +1,58 @@
 package loyalty
 
 type StatusLevel struct {
-	Ordinal       int
-	Name          string
-	MinimumPoints int
-	GuestsAllowed int
+	Ordinal       int    `yaml:"-"`
+	Name          string `yaml:"name"`
+	MinimumPoints int    `yaml:"minimumPoints"`
+	// Benefits is how much of each benefit the level grants per qualification period.
+	Benefits map[Benefit]int `yaml:"benefits"`
+	// EarningMultiplier scales points earned from purchases. Zero means no multiplier.
+	EarningMultiplier float64 `yaml:"earningMultiplier"`
 }
 
-func newStatusLevel(ord int, name string, minPoints int, guests int) *StatusLevel {
-	return &StatusLevel{Ordinal: ord, Name: name, MinimumPoints: minPoints, GuestsAllowed: guests}
+func newStatusLevel(ord int, name string, minPoints int, benefits map[Benefit]int) *StatusLevel {
+	return &StatusLevel{Ordinal: ord, Name: name, MinimumPoints: minPoints, Benefits: benefits}
 }
 
-var StatusLevels = []*StatusLevel{
-	newStatusLevel(0, "Member", 0, 0),
-	newStatusLevel(1, "Bronze", 500, 1),
-	newStatusLevel(2, "Silver", 1000, 2),
-	newStatusLevel(3, "Gold", 2000, 5),
-	newStatusLevel(4, "Platinum", 5000, 10),
+// Multiplier returns the level's earning multiplier, treating an unset multiplier as 1.
+func (s *StatusLevel) Multiplier() float64 {
+	if s.EarningMultiplier == 0 {
+		return 1
+	}
+	return s.EarningMultiplier
 }
 
-func StatusLevelForPoints(points int) *StatusLevel {
-	for i, level := range StatusLevels {
+// StatusLevelForPoints returns the highest status level whose minimum the given points meet.
+func (p *Program) StatusLevelForPoints(points int) *StatusLevel {
+	for i, level := range p.StatusLevels {
 		if i > 0 && points < level.MinimumPoints {
-			return StatusLevels[i-1]
+			return p.StatusLevels[i-1]
+		}
+	}
+	return p.StatusLevels[len(p.StatusLevels)-1]
+}
+
+// statusLevelFor returns the level a MinimumStatus asks for: the level with the same name, or for payloads without a
+// name, the level at its ordinal. It returns nil if that level isn't in this program.
+func (p *Program) statusLevelFor(minStatus MinimumStatus) *StatusLevel {
+	if minStatus.Status != "" {
+		for _, level := range p.StatusLevels {
+			if level.Name == minStatus.Status {
+				return level
+			}
 		}
+		return nil
+	}
+	if minStatus.Ordinal < 0 || minStatus.Ordinal >= len(p.StatusLevels) {
+		return nil
 	}
-	return StatusLevels[len(StatusLevels)-1]
+	return p.StatusLevels[minStatus.Ordinal]
 }
 
-// Previous returns nil if already at lowest, otherwise one StatusLevel lower than the current.
-func (s *StatusLevel) Previous() *StatusLevel {
+// Previous returns nil if already at lowest, otherwise one StatusLevel lower than the given one.
+func (p *Program) Previous(s *StatusLevel) *StatusLevel {
 	if s.Ordinal > 0 {
-		return StatusLevels[s.Ordinal-1]
+		return p.StatusLevels[s.Ordinal-1]
 	}
 	return nil
 }
@@ -0,0 +1,540 @@
+package loyalty
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateName identifies an email template. Workflows only ever send a template's name and parameters; the text is
+// rendered by the SendEmail activity, so templates can change without affecting running workflows.
+type TemplateName string
+
+const (
+	TemplateWelcome            TemplateName = "welcome"
+	TemplateGuestCanceled      TemplateName = "guestCanceled"
+	TemplateGuestInvited       TemplateName = "guestInvited"
+	TemplateGuestAlreadyMember TemplateName = "guestAlreadyMember"
+	TemplateGuestUpgraded      TemplateName = "guestUpgraded"
+	TemplateGuestInviteFailed  TemplateName = "guestInviteFailed"
+	TemplateGuestInvitation    TemplateName = "guestInvitation"
+	TemplateInvitationSent     TemplateName = "invitationSent"
+	TemplateInvitationExpired  TemplateName = "invitationExpired"
+	TemplateGuestRevoked       TemplateName = "guestRevoked"
+	TemplateSponsorshipRevoked TemplateName = "sponsorshipRevoked"
+	TemplateInsufficientPoints TemplateName = "insufficientPoints"
+	TemplatePromoted           TemplateName = "promoted"
+	TemplateDemoted            TemplateName = "demoted"
+	TemplateStatusRetained     TemplateName = "statusRetained"
+	TemplateCancelAccount      TemplateName = "cancelAccount"
+	TemplateAccountSuspended   TemplateName = "accountSuspended"
+	TemplateAccountReactivated TemplateName = "accountReactivated"
+	TemplateAccountClosing     TemplateName = "accountClosing"
+	TemplateRewardRedeemed     TemplateName = "rewardRedeemed"
+	TemplateRedemptionFailed   TemplateName = "redemptionFailed"
+	TemplateBenefitUsed        TemplateName = "benefitUsed"
+	TemplatePointsExpiring     TemplateName = "pointsExpiring"
+	TemplatePointsExpired      TemplateName = "pointsExpired"
+	// TemplateCampaignAnnouncement is marketing; see marketingTemplates.
+	TemplateCampaignAnnouncement TemplateName = "campaignAnnouncement"
+	// TemplateDigest combines several low-priority notifications; see digestEmail.
+	TemplateDigest TemplateName = "digest"
+)
+
+// DefaultLocale is used for customers without a language preference, and for templates that haven't been translated
+// into the customer's language.
+const DefaultLocale = "en"
+
+// EmailParams are the values templates refer to. Use the email constructors below, which set exactly the parameters
+// their template needs.
+type EmailParams struct {
+	Status    string  `json:",omitempty"`
+	GuestID   string  `json:",omitempty"`
+	Code      string  `json:",omitempty"`
+	Reward    string  `json:",omitempty"`
+	Benefit   Benefit `json:",omitempty"`
+	Campaign  string  `json:",omitempty"`
+	Points    int     `json:",omitempty"`
+	Remaining int     `json:",omitempty"`
+	// Multiplier is a campaign's points multiplier.
+	Multiplier float64   `json:",omitempty"`
+	Date       time.Time `json:",omitempty"`
+	// Digest holds the notifications a digest combines. Each is rendered in the digest's locale.
+	Digest []Email `json:",omitempty"`
+}
+
+// in returns the parameters with dates, including those of any digest items, shown in loc.
+func (p EmailParams) in(loc *time.Location) EmailParams {
+	p.Date = p.Date.In(loc)
+	if p.Digest != nil {
+		items := make([]Email, len(p.Digest))
+		for i, item := range p.Digest {
+			item.Params = item.Params.in(loc)
+			items[i] = item
+		}
+		p.Digest = items
+	}
+	return p
+}
+
+// templateData is what templates are executed with: the email's parameters, plus the rendered text of each item
+// in a digest.
+type templateData struct {
+	EmailParams
+	Items []string
+}
+
+// Email is a templated message for the SendEmail activity to render in the given locale.
+type Email struct {
+	// To is the recipient's address. Emails without one are only logged.
+	To       string `json:",omitempty"`
+	Template TemplateName
+	Locale   string
+	Params   EmailParams
+	// IdempotencyKey identifies this email across activity retries; see notificationKey.
+	IdempotencyKey string `json:",omitempty"`
+	// Body is a pre-rendered message from workflows that predate templates. It's sent as-is.
+	Body string `json:",omitempty"`
+}
+
+// UnmarshalJSON accepts either a full Email or, from workflows that predate templates, a bare message body.
+func (e *Email) UnmarshalJSON(data []byte) error {
+	var body string
+	if err := json.Unmarshal(data, &body); err == nil {
+		*e = Email{Body: body}
+		return nil
+	}
+
+	type email Email
+	return json.Unmarshal(data, (*email)(e))
+}
+
+// RenderedEmail is an email ready to deliver.
+type RenderedEmail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// emailTemplate holds the text/template sources for one locale's version of a template. The HTML body is optional;
+// without one, the text body is wrapped in emailLayout.
+type emailTemplate struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+var emailLayout = htmltemplate.Must(htmltemplate.New("layout").Parse(
+	`<!DOCTYPE html><html><body><p>{{.}}</p></body></html>`))
+
+var emailTemplates = map[TemplateName]map[string]emailTemplate{
+	TemplateWelcome: {
+		"en": {
+			Subject: "Welcome to our loyalty program",
+			Text:    "Welcome to our loyalty program! You're starting out at '{{.Status}}' status.",
+			HTML:    "<!DOCTYPE html><html><body><h1>Welcome!</h1><p>You're starting out at <strong>{{.Status}}</strong> status.</p></body></html>",
+		},
+		"es": {
+			Subject: "Bienvenido a nuestro programa de fidelidad",
+			Text:    "¡Bienvenido a nuestro programa de fidelidad! Empiezas con el estado '{{.Status}}'.",
+			HTML:    "<!DOCTYPE html><html><body><h1>¡Bienvenido!</h1><p>Empiezas con el estado <strong>{{.Status}}</strong>.</p></body></html>",
+		},
+	},
+	TemplateGuestCanceled: {
+		"en": {Subject: "Your guest's account is closed", Text: "Sorry, your guest has already canceled their account."},
+		"es": {Subject: "La cuenta de tu invitado está cerrada", Text: "Lo sentimos, tu invitado ya canceló su cuenta."},
+	},
+	TemplateGuestInvited: {
+		"en": {Subject: "Your guest has been invited", Text: "Congratulations! Your guest has been invited!"},
+		"es": {Subject: "Tu invitado ha sido invitado", Text: "¡Felicidades! Tu invitado ha sido invitado."},
+	},
+	TemplateGuestAlreadyMember: {
+		"en": {
+			Subject: "Your guest is already a member",
+			Text:    "Your guest is already a member with at least the status you can share, so they'll keep their current status.",
+		},
+		"es": {
+			Subject: "Tu invitado ya es miembro",
+			Text:    "Tu invitado ya es miembro con al menos el estado que puedes compartir, así que conservará su estado actual.",
+		},
+	},
+	TemplateGuestUpgraded: {
+		"en": {Subject: "Your guest has been upgraded", Text: "Congratulations! Your guest already had an account, and it's been upgraded!"},
+		"es": {Subject: "Tu invitado ha subido de estado", Text: "¡Felicidades! Tu invitado ya tenía una cuenta y ha subido de estado."},
+	},
+	TemplateGuestInviteFailed: {
+		"en": {
+			Subject: "We couldn't invite your guest",
+			Text:    "Sorry, we couldn't invite your guest '{{.GuestID}}'. Your guest slot is still available.",
+		},
+		"es": {
+			Subject: "No pudimos invitar a tu invitado",
+			Text:    "Lo sentimos, no pudimos invitar a '{{.GuestID}}'. Tu plaza de invitado sigue disponible.",
+		},
+	},
+	TemplateGuestInvitation: {
+		"en": {
+			Subject: "You've been invited to our loyalty program",
+			Text: "You've been invited to join our loyalty program as a guest! Use code '{{.Code}}' to accept before " +
+				"{{.Date.Format \"Mon, 02 Jan 2006 15:04 MST\"}}.",
+		},
+		"es": {
+			Subject: "Te han invitado a nuestro programa de fidelidad",
+			Text: "¡Te han invitado a unirte a nuestro programa de fidelidad! Usa el código '{{.Code}}' para aceptar antes " +
+				"del {{.Date.Format \"02/01/2006 15:04 MST\"}}.",
+		},
+	},
+	TemplateInvitationSent: {
+		"en": {
+			Subject: "Your guest's invitation is on its way",
+			Text: "We've sent '{{.GuestID}}' an invitation to join as your guest. They have until " +
+				"{{.Date.Format \"Mon, 02 Jan 2006 15:04 MST\"}} to accept.",
+		},
+		"es": {
+			Subject: "La invitación de tu invitado está en camino",
+			Text: "Hemos enviado a '{{.GuestID}}' una invitación para unirse como tu invitado. Tiene hasta el " +
+				"{{.Date.Format \"02/01/2006 15:04 MST\"}} para aceptar.",
+		},
+	},
+	TemplateInvitationExpired: {
+		"en": {
+			Subject: "Your guest invitation expired",
+			Text:    "Your invitation to '{{.GuestID}}' expired before they accepted it, so the guest slot is free again.",
+		},
+		"es": {
+			Subject: "Tu invitación ha caducado",
+			Text:    "Tu invitación a '{{.GuestID}}' caducó antes de que la aceptara, así que la plaza de invitado vuelve a estar libre.",
+		},
+	},
+	TemplateGuestRevoked: {
+		"en": {
+			Subject: "Your guest has been removed",
+			Text:    "Your guest '{{.GuestID}}' has been removed, freeing up one of your guest slots.",
+		},
+		"es": {
+			Subject: "Tu invitado ha sido eliminado",
+			Text:    "Hemos eliminado a tu invitado '{{.GuestID}}', liberando una de tus plazas de invitado.",
+		},
+	},
+	TemplateSponsorshipRevoked: {
+		"en": {Subject: "Your guest status has ended", Text: "Your guest status has ended. You're now at '{{.Status}}' status."},
+		"es": {Subject: "Tu estado de invitado ha terminado", Text: "Tu estado de invitado ha terminado. Ahora tienes el estado '{{.Status}}'."},
+	},
+	TemplateInsufficientPoints: {
+		"en": {Subject: "You can't invite more guests yet", Text: "Sorry, you need to earn more points to invite more guests!"},
+		"es": {Subject: "Aún no puedes invitar a más personas", Text: "Lo sentimos, necesitas ganar más puntos para invitar a más personas."},
+	},
+	TemplatePromoted: {
+		"en": {Subject: "You've been promoted!", Text: "Congratulations! You've been promoted to '{{.Status}}' status!"},
+		"es": {Subject: "¡Has subido de estado!", Text: "¡Felicidades! Has subido al estado '{{.Status}}'."},
+	},
+	TemplateDemoted: {
+		"en": {
+			Subject: "Your status has changed",
+			Text:    "Unfortunately, you've lost enough points to bump you down to '{{.Status}}' status. 😞",
+		},
+		"es": {
+			Subject: "Tu estado ha cambiado",
+			Text:    "Lamentablemente, has perdido suficientes puntos para bajar al estado '{{.Status}}'. 😞",
+		},
+	},
+	TemplateStatusRetained: {
+		"en": {Subject: "You've kept your status", Text: "Congratulations! You've kept your '{{.Status}}' status for another year."},
+		"es": {Subject: "Has conservado tu estado", Text: "¡Felicidades! Conservas tu estado '{{.Status}}' un año más."},
+	},
+	TemplateCancelAccount: {
+		"en": {Subject: "Your account has been canceled", Text: "Sorry to see you go!"},
+		"es": {Subject: "Tu cuenta ha sido cancelada", Text: "¡Lamentamos que te vayas!"},
+	},
+	TemplateAccountSuspended: {
+		"en": {
+			Subject: "Your account has been suspended",
+			Text: "Your account has been suspended. You won't earn points or be able to redeem them until it's " +
+				"reactivated. Please contact us if you have any questions.",
+		},
+		"es": {
+			Subject: "Tu cuenta ha sido suspendida",
+			Text: "Tu cuenta ha sido suspendida. No ganarás ni podrás canjear puntos hasta que se reactive. " +
+				"Contáctanos si tienes alguna pregunta.",
+		},
+	},
+	TemplateAccountReactivated: {
+		"en": {Subject: "Your account is active again", Text: "Welcome back! Your account is active again."},
+		"es": {Subject: "Tu cuenta está activa de nuevo", Text: "¡Bienvenido de nuevo! Tu cuenta está activa otra vez."},
+	},
+	TemplateAccountClosing: {
+		"en": {
+			Subject: "Your account is closing",
+			Text: "Your account will close on {{.Date.Format \"January 2, 2006\"}}. Changed your mind? Reactivate it " +
+				"before then to keep your points and status.",
+		},
+		"es": {
+			Subject: "Tu cuenta se cerrará",
+			Text: "Tu cuenta se cerrará el {{.Date.Format \"02/01/2006\"}}. ¿Cambiaste de opinión? Reactívala antes " +
+				"para conservar tus puntos y tu estado.",
+		},
+	},
+	TemplateRewardRedeemed: {
+		"en": {
+			Subject: "Enjoy your reward",
+			Text:    "Enjoy your '{{.Reward}}'! {{.Points}} points have been deducted from your balance.",
+		},
+		"es": {
+			Subject: "Disfruta de tu recompensa",
+			Text:    "¡Disfruta de tu '{{.Reward}}'! Hemos descontado {{.Points}} puntos de tu saldo.",
+		},
+	},
+	TemplateRedemptionFailed: {
+		"en": {
+			Subject: "We couldn't fulfill your reward",
+			Text:    "Sorry, we couldn't fulfill your '{{.Reward}}'. Your {{.Points}} points have been refunded.",
+		},
+		"es": {
+			Subject: "No pudimos entregar tu recompensa",
+			Text:    "Lo sentimos, no pudimos entregar tu '{{.Reward}}'. Te hemos devuelto tus {{.Points}} puntos.",
+		},
+	},
+	TemplateBenefitUsed: {
+		"en": {Subject: "Enjoy your benefit", Text: "Enjoy! You have {{.Remaining}} '{{.Benefit}}' remaining this year."},
+		"es": {Subject: "Disfruta de tu beneficio", Text: "¡Que lo disfrutes! Te quedan {{.Remaining}} '{{.Benefit}}' este año."},
+	},
+	TemplatePointsExpiring: {
+		"en": {
+			Subject: "Your points are expiring soon",
+			Text:    "Heads up! {{.Points}} of your points will expire on {{.Date.Format \"January 2, 2006\"}}.",
+		},
+		"es": {
+			Subject: "Tus puntos caducarán pronto",
+			Text:    "¡Atención! {{.Points}} de tus puntos caducarán el {{.Date.Format \"02/01/2006\"}}.",
+		},
+	},
+	TemplatePointsExpired: {
+		"en": {Subject: "Your points have expired", Text: "{{.Points}} of your points have expired."},
+		"es": {Subject: "Tus puntos han caducado", Text: "{{.Points}} de tus puntos han caducado."},
+	},
+	TemplateDigest: {
+		"en": {
+			Subject: "Your loyalty program updates",
+			Text:    "Here's what's new with your account:{{range .Items}}\n- {{.}}{{end}}",
+			HTML:    "<!DOCTYPE html><html><body><p>Here's what's new with your account:</p><ul>{{range .Items}}<li>{{.}}</li>{{end}}</ul></body></html>",
+		},
+		"es": {
+			Subject: "Novedades de tu programa de fidelidad",
+			Text:    "Esto es lo nuevo en tu cuenta:{{range .Items}}\n- {{.}}{{end}}",
+			HTML:    "<!DOCTYPE html><html><body><p>Esto es lo nuevo en tu cuenta:</p><ul>{{range .Items}}<li>{{.}}</li>{{end}}</ul></body></html>",
+		},
+	},
+	TemplateCampaignAnnouncement: {
+		"en": {
+			Subject: "{{.Campaign}}: earn {{.Multiplier}}x points",
+			Text:    "{{.Campaign}} is on! Earn {{.Multiplier}}x points on purchases until {{.Date.Format \"January 2, 2006\"}}.",
+		},
+		"es": {
+			Subject: "{{.Campaign}}: gana {{.Multiplier}}x puntos",
+			Text:    "¡{{.Campaign}} ha comenzado! Gana {{.Multiplier}}x puntos en tus compras hasta el {{.Date.Format \"02/01/2006\"}}.",
+		},
+	},
+}
+
+// templateFor picks the closest match to the locale: an exact match ("es-MX"), then the language ("es"), then
+// DefaultLocale.
+func templateFor(name TemplateName, locale string) (emailTemplate, error) {
+	locales, ok := emailTemplates[name]
+	if !ok {
+		return emailTemplate{}, fmt.Errorf("unknown email template '%v'", name)
+	}
+
+	candidates := []string{locale}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, language)
+	}
+	candidates = append(candidates, DefaultLocale)
+	for _, candidate := range candidates {
+		if t, ok := locales[candidate]; ok {
+			return t, nil
+		}
+	}
+	return emailTemplate{}, fmt.Errorf("email template '%v' has no '%v' version", name, DefaultLocale)
+}
+
+// RenderEmail renders the email's template in its locale. Emails with a pre-rendered Body are sent as plain text.
+func RenderEmail(email Email) (RenderedEmail, error) {
+	if email.Template == "" {
+		return RenderedEmail{Text: email.Body, HTML: renderLayout(email.Body)}, nil
+	}
+
+	t, err := templateFor(email.Template, email.Locale)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+
+	data := templateData{EmailParams: email.Params}
+	for _, item := range email.Params.Digest {
+		item.Locale = email.Locale
+		r, err := RenderEmail(item)
+		if err != nil {
+			return RenderedEmail{}, fmt.Errorf("unable to render digest item: %w", err)
+		}
+		data.Items = append(data.Items, r.Text)
+	}
+
+	var rendered RenderedEmail
+	rendered.Subject, err = renderText(t.Subject, data)
+	if err != nil {
+		return RenderedEmail{}, fmt.Errorf("unable to render subject of '%v': %w", email.Template, err)
+	}
+	rendered.Text, err = renderText(t.Text, data)
+	if err != nil {
+		return RenderedEmail{}, fmt.Errorf("unable to render text of '%v': %w", email.Template, err)
+	}
+	if t.HTML == "" {
+		rendered.HTML = renderLayout(rendered.Text)
+	} else {
+		rendered.HTML, err = renderHTML(t.HTML, data)
+		if err != nil {
+			return RenderedEmail{}, fmt.Errorf("unable to render HTML of '%v': %w", email.Template, err)
+		}
+	}
+	return rendered, nil
+}
+
+func renderText(source string, data templateData) (string, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
+
+func renderHTML(source string, data templateData) (string, error) {
+	t, err := htmltemplate.New("").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
+
+func renderLayout(text string) string {
+	var b bytes.Buffer
+	// the layout is a constant and text is escaped, so this can't fail
+	_ = emailLayout.Execute(&b, text)
+	return b.String()
+}
+
+func welcomeEmail(status string) Email {
+	return Email{Template: TemplateWelcome, Params: EmailParams{Status: status}}
+}
+
+func guestCanceledEmail() Email {
+	return Email{Template: TemplateGuestCanceled}
+}
+
+func guestInvitedEmail() Email {
+	return Email{Template: TemplateGuestInvited}
+}
+
+func guestAlreadyMemberEmail() Email {
+	return Email{Template: TemplateGuestAlreadyMember}
+}
+
+func guestUpgradedEmail() Email {
+	return Email{Template: TemplateGuestUpgraded}
+}
+
+func guestInviteFailedEmail(guestID string) Email {
+	return Email{Template: TemplateGuestInviteFailed, Params: EmailParams{GuestID: guestID}}
+}
+
+func guestInvitationEmail(code string, expiresAt time.Time) Email {
+	return Email{Template: TemplateGuestInvitation, Params: EmailParams{Code: code, Date: expiresAt}}
+}
+
+func invitationSentEmail(guestID string, expiresAt time.Time) Email {
+	return Email{Template: TemplateInvitationSent, Params: EmailParams{GuestID: guestID, Date: expiresAt}}
+}
+
+func invitationExpiredEmail(guestID string) Email {
+	return Email{Template: TemplateInvitationExpired, Params: EmailParams{GuestID: guestID}}
+}
+
+func guestRevokedEmail(guestID string) Email {
+	return Email{Template: TemplateGuestRevoked, Params: EmailParams{GuestID: guestID}}
+}
+
+func sponsorshipRevokedEmail(status string) Email {
+	return Email{Template: TemplateSponsorshipRevoked, Params: EmailParams{Status: status}}
+}
+
+func insufficientPointsEmail() Email {
+	return Email{Template: TemplateInsufficientPoints}
+}
+
+func promotedEmail(status string) Email {
+	return Email{Template: TemplatePromoted, Params: EmailParams{Status: status}}
+}
+
+func demotedEmail(status string) Email {
+	return Email{Template: TemplateDemoted, Params: EmailParams{Status: status}}
+}
+
+func statusRetainedEmail(status string) Email {
+	return Email{Template: TemplateStatusRetained, Params: EmailParams{Status: status}}
+}
+
+func cancelAccountEmail() Email {
+	return Email{Template: TemplateCancelAccount}
+}
+
+func accountSuspendedEmail() Email {
+	return Email{Template: TemplateAccountSuspended}
+}
+
+func accountReactivatedEmail() Email {
+	return Email{Template: TemplateAccountReactivated}
+}
+
+func accountClosingEmail(closesAt time.Time) Email {
+	return Email{Template: TemplateAccountClosing, Params: EmailParams{Date: closesAt}}
+}
+
+func rewardRedeemedEmail(reward string, points int) Email {
+	return Email{Template: TemplateRewardRedeemed, Params: EmailParams{Reward: reward, Points: points}}
+}
+
+func redemptionFailedEmail(reward string, points int) Email {
+	return Email{Template: TemplateRedemptionFailed, Params: EmailParams{Reward: reward, Points: points}}
+}
+
+func benefitUsedEmail(benefit Benefit, remaining int) Email {
+	return Email{Template: TemplateBenefitUsed, Params: EmailParams{Benefit: benefit, Remaining: remaining}}
+}
+
+func pointsExpiringEmail(points int, expiresAt time.Time) Email {
+	return Email{Template: TemplatePointsExpiring, Params: EmailParams{Points: points, Date: expiresAt}}
+}
+
+func pointsExpiredEmail(points int) Email {
+	return Email{Template: TemplatePointsExpired, Params: EmailParams{Points: points}}
+}
+
+// digestEmail combines notifications into one. Their dates are shown in the customer's time zone, like the
+// digest's own.
+func digestEmail(items []Email) Email {
+	return Email{Template: TemplateDigest, Params: EmailParams{Digest: items}}
+}
+
+func campaignAnnouncementEmail(campaign Campaign) Email {
+	return Email{Template: TemplateCampaignAnnouncement, Params: EmailParams{
+		Campaign:   campaign.Name,
+		Multiplier: campaign.Multiplier,
+		Date:       campaign.End,
+	}}
+}
@@ -0,0 +1,75 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func (s *UnitTestSuite) Test_RenderEmail() {
+	rendered, err := RenderEmail(welcomeEmail("Gold"))
+	s.NoError(err)
+	s.Equal("Welcome to our loyalty program", rendered.Subject)
+	s.Equal("Welcome to our loyalty program! You're starting out at 'Gold' status.", rendered.Text)
+	s.Contains(rendered.HTML, "<strong>Gold</strong>")
+
+	expiresAt := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	rendered, err = RenderEmail(pointsExpiringEmail(100, expiresAt))
+	s.NoError(err)
+	s.Equal("Heads up! 100 of your points will expire on March 5, 2024.", rendered.Text)
+
+	// templates without their own HTML body are wrapped in the layout, escaped
+	rendered, err = RenderEmail(guestRevokedEmail("<guest>"))
+	s.NoError(err)
+	s.Contains(rendered.HTML, "&lt;guest&gt;")
+	s.NotContains(rendered.HTML, "<guest>")
+
+	_, err = RenderEmail(Email{Template: "noSuchTemplate"})
+	s.ErrorContains(err, "unknown email template 'noSuchTemplate'")
+}
+
+func (s *UnitTestSuite) Test_RenderEmailLocale() {
+	email := promotedEmail("Oro")
+
+	email.Locale = "es"
+	rendered, err := RenderEmail(email)
+	s.NoError(err)
+	s.Equal("¡Felicidades! Has subido al estado 'Oro'.", rendered.Text)
+
+	// regional variants fall back to their language, then to the default
+	email.Locale = "es-MX"
+	regional, err := RenderEmail(email)
+	s.NoError(err)
+	s.Equal(rendered, regional)
+
+	email.Locale = "fr-CA"
+	rendered, err = RenderEmail(email)
+	s.NoError(err)
+	s.Equal("Congratulations! You've been promoted to 'Oro' status!", rendered.Text)
+}
+
+func (s *UnitTestSuite) Test_EmailLegacyPayload() {
+	var email Email
+	s.NoError(json.Unmarshal([]byte(`"Sorry to see you go!"`), &email))
+	s.Equal(Email{Body: "Sorry to see you go!"}, email)
+
+	rendered, err := RenderEmail(email)
+	s.NoError(err)
+	s.Equal("Sorry to see you go!", rendered.Text)
+
+	data, err := json.Marshal(welcomeEmail("Member"))
+	s.NoError(err)
+	var decoded Email
+	s.NoError(json.Unmarshal(data, &decoded))
+	s.Equal(welcomeEmail("Member"), decoded)
+}
+
+func (s *UnitTestSuite) Test_EveryTemplateHasDefaultLocale() {
+	for name, locales := range emailTemplates {
+		_, ok := locales[DefaultLocale]
+		s.True(ok, name)
+		for locale := range locales {
+			_, err := RenderEmail(Email{Template: name, Locale: locale})
+			s.NoError(err, "%v/%v", name, locale)
+		}
+	}
+}
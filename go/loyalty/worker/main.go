@@ -1,7 +1,12 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -12,6 +17,20 @@ import (
 )
 
 func main() {
+	mailer := flag.String("mailer", "log", "How to deliver email: 'log', 'file' or 'smtp'.")
+	mailDir := flag.String("mail-dir", "mail", "Directory the 'file' mailer writes messages to.")
+	outbox := flag.String("outbox", "outbox", "Directory recording delivered notifications, so retries don't "+
+		"resend them. Empty disables deduplication.")
+	smtpAddr := flag.String("smtp-addr", "localhost:25", "SMTP relay host:port for the 'smtp' mailer.")
+	smtpUser := flag.String("smtp-user", "", "SMTP username. The password is read from SMTP_PASSWORD.")
+	from := flag.String("from", "Loyalty Program <loyalty@example.com>", "Sender address for all email.")
+	rateLimit := flag.Float64("email-rate", 10, "Maximum emails sent per second.")
+	rateBurst := flag.Int("email-burst", 10, "Maximum burst of emails sent at once.")
+	webhooks := flag.Bool("push-webhooks", false, "Deliver push notifications by POSTing them to customers' endpoints.")
+	pushHosts := flag.String("push-hosts", "", "Comma-separated hosts push endpoints may be on; a leading '.' "+
+		"allows subdomains. Required with -push-webhooks.")
+	flag.Parse()
+
 	logger := wf.NewZapAdapter(wf.NewZapLogger(zapcore.DebugLevel))
 	c, err := client.Dial(client.Options{
 		Logger: logger,
@@ -24,9 +43,37 @@ func main() {
 	w := worker.New(c, wf.TaskQueue, worker.Options{})
 
 	a := &wf.Activities{
-		Client: c,
+		Client:    c,
+		EmailFrom: *from,
+	}
+	switch *mailer {
+	case "log":
+	case "file":
+		a.Mailer = wf.NewRateLimitedMailer(&wf.FileMailer{Dir: *mailDir}, *rateLimit, *rateBurst)
+	case "smtp":
+		m := &wf.SMTPMailer{Addr: *smtpAddr, Logger: logger}
+		if *smtpUser != "" {
+			host, _, err := net.SplitHostPort(*smtpAddr)
+			if err != nil {
+				log.Fatalln("Invalid SMTP address.", err)
+			}
+			m.Auth = smtp.PlainAuth("", *smtpUser, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		a.Mailer = wf.NewRateLimitedMailer(m, *rateLimit, *rateBurst)
+	default:
+		log.Fatalln("Unknown mailer.", *mailer)
+	}
+	if *webhooks {
+		if *pushHosts == "" {
+			log.Fatalln("-push-webhooks requires -push-hosts.")
+		}
+		a.PushSender = &wf.WebhookPushSender{AllowedHosts: strings.Split(*pushHosts, ",")}
+	}
+	if *outbox != "" {
+		a.Outbox = &wf.FileOutbox{Dir: *outbox}
 	}
 	w.RegisterWorkflow(wf.CustomerLoyaltyWorkflow)
+	w.RegisterWorkflow(wf.CampaignFanOutWorkflow)
 	w.RegisterActivity(a)
 
 	err = w.Run(worker.InterruptCh())
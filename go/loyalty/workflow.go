@@ -1,9 +1,11 @@
 package loyalty
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
@@ -11,27 +13,49 @@ import (
 const TaskQueue = "CustomerLoyaltyTaskQueue"
 const EventsThreshold = 10_000
 
+// CustomerWorkflowIDPrefix prefixes every customer's Workflow ID.
+const CustomerWorkflowIDPrefix = "customer-"
+
 // Signal, query, and error string constants
 const (
 	SignalCancelAccount       = "cancelAccount"
 	SignalAddPoints           = "addLoyaltyPoints"
 	SignalInviteGuest         = "inviteGuest"
 	SignalEnsureMinimumStatus = "ensureMinimumStatus"
+	SignalRedeemReward        = "redeemReward"
+	SignalUseBenefit          = "useBenefit"
+	SignalPurchase            = "purchase"
+	SignalAddCampaign         = "addCampaign"
+	SignalRevokeGuest         = "revokeGuest"
+	SignalRevokeSponsorship   = "revokeSponsorship"
+	SignalAcceptInvitation    = "acceptInvitation"
+	SignalGuestStatus         = "guestStatus"
+	SignalUpdateContact       = "updateContactProfile"
+	SignalSuspendAccount      = "suspendAccount"
+	SignalReactivateAccount   = "reactivateAccount"
 	QueryGetStatus            = "getStatus"
 	QueryGetGuests            = "getGuests"
+	QueryGetEntitlements      = "getEntitlements"
+	QueryGetPointsHistory     = "getPointsHistory"
+	QueryGetInvitations       = "getInvitations"
+	QueryGetGuestDetails      = "getGuestDetails"
+	QueryGetContact           = "getContactProfile"
+	UpdateAddPoints           = "addLoyaltyPointsUpdate"
+	UpdateInviteGuest         = "inviteGuestUpdate"
+	UpdateRevokeGuest         = "revokeGuestUpdate"
+	UpdateAcceptInvitation    = "acceptInvitationUpdate"
+	UpdateRedeemReward        = "redeemRewardUpdate"
+	UpdateUseBenefit          = "useBenefitUpdate"
+	UpdatePurchase            = "purchaseUpdate"
+	UpdateContact             = "updateContactProfileUpdate"
+	UpdateSuspendAccount      = "suspendAccountUpdate"
+	UpdateReactivateAccount   = "reactivateAccountUpdate"
+	UpdateCancelAccount       = "cancelAccountUpdate"
 )
 
-const (
-	emailWelcome            = "Welcome to our loyalty program! You're starting out at '%v' status."
-	emailGuestCanceled      = "Sorry, your guest has already canceled their account."
-	emailGuestInvited       = "Congratulations! Your guest has been invited!"
-	emailInsufficientPoints = "Sorry, you need to earn more points to invite more guests!"
-	emailPromoted           = "Congratulations! You've been promoted to '%v' status!"
-	emailDemoted            = "Unfortunately, you've lost enough points to bump you down to '%v' status. 😞"
-	emailCancelAccount      = "Sorry to see you go!"
-)
-
-func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCustomer bool) error {
+// CustomerLoyaltyWorkflow runs a customer's account until it's closed, continuing as new as its history grows. The
+// result is the customer's final state, which ReopenAccount can restore from.
+func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCustomer bool) (CustomerInfo, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Loyalty workflow started.", "CustomerInfo", customer)
 
@@ -47,17 +71,51 @@ func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCus
 
 	info := workflow.GetInfo(ctx)
 	selector := workflow.NewSelector(ctx)
-	var activities Activities
 	workflowCanceled := false
 	var errSignal error
+	inFlightUpdates := 0
+
+	// Pin the customer to the current program if they predate versioned programs, and refuse to run a broken one.
+	if customer.Program == nil {
+		customer.Program = DefaultProgram()
+	}
+	err := customer.Program.Validate()
+	if err != nil {
+		return CustomerInfo{}, err
+	}
+
+	// Customers that predate account states are either active or closed.
+	if customer.Account.State == "" {
+		state := AccountStateClosed
+		if customer.AccountActive {
+			state = AccountStateActive
+		}
+		customer.setAccountState(state, "", workflow.Now(ctx))
+	}
+
+	// Customers that predate qualifying points, or were created with only a balance, qualify with their balance.
+	if customer.QualificationPeriodStart.IsZero() {
+		if customer.QualifyingPoints == 0 {
+			customer.QualifyingPoints = customer.LoyaltyPoints
+		}
+		customer.QualificationPeriodStart = workflow.Now(ctx)
+	}
+	customer.promote()
+	customer.reconcileBatches(workflow.Now(ctx))
+
+	// Runs started before transaction IDs were namespaced keep recording bare IDs, so they replay the same way.
+	customer.namespacedTransactions =
+		workflow.GetVersion(ctx, "transaction-namespaces", workflow.DefaultVersion, 1) == 1
+
+	// Runs started before annual requalification was introduced don't schedule its timer until they continue-as-new.
+	customer.annualRequalification =
+		workflow.GetVersion(ctx, "requalification-timer", workflow.DefaultVersion, 1) > workflow.DefaultVersion
 
 	if newCustomer {
 		logger.Info("New customer workflow; sending welcome email.")
-		err := workflow.ExecuteActivity(ctx, activities.SendEmail,
-			fmt.Sprintf(emailWelcome, StatusLevelForPoints(customer.LoyaltyPoints).Name)).
-			Get(ctx, nil)
+		err := notify(ctx, &customer, welcomeEmail(customer.status().Name))
 		if err != nil {
-			logger.Error("Error running SendEmail activity for welcome email.", "Error", err)
+			logger.Error("Error running SendNotification activity for welcome email.", "Error", err)
 		}
 	} else {
 		logger.Info("Continued workflow execution for existing customer.")
@@ -66,37 +124,175 @@ func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCus
 	// signal handler for adding points
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalAddPoints),
 		func(c workflow.ReceiveChannel, _ bool) {
-			var pointsToAdd int
-			c.Receive(ctx, &pointsToAdd)
+			var transaction PointsTransaction
+			c.Receive(ctx, &transaction)
+
+			signalAddPoints(ctx, transaction, &customer)
+		})
+
+	// signal handler for purchases, which are converted to points by the accrual engine
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalPurchase),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var purchase Purchase
+			c.Receive(ctx, &purchase)
+
+			err := signalPurchase(ctx, purchase, &customer)
+			if err != nil {
+				logger.Warn("Unable to credit purchase.", "Purchase", purchase, "Error", err)
+			}
+		})
+
+	// signal handler for promotional campaigns
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalAddCampaign),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var campaign Campaign
+			c.Receive(ctx, &campaign)
 
-			signalAddPoints(ctx, pointsToAdd, &customer)
+			signalAddCampaign(ctx, campaign, &customer)
 		})
 
 	// signal handler for adding guest
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalInviteGuest),
 		func(c workflow.ReceiveChannel, _ bool) {
-			var guestID string
-			c.Receive(ctx, &guestID)
+			var request InviteGuestRequest
+			c.Receive(ctx, &request)
 
-			errSignal = signalInviteGuest(ctx, guestID, &customer)
+			err := signalInviteGuest(ctx, request, &customer)
+			if isCorruptGuestState(err) {
+				errSignal = err
+			} else if err != nil {
+				logger.Warn("Unable to invite guest.", "GuestID", request.GuestID, "Error", err)
+			}
 		})
 
 	// signal handler for ensuring the customer is at least the given status. Used for invites and promoting an existing account.
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalEnsureMinimumStatus),
 		func(c workflow.ReceiveChannel, _ bool) {
-			var minStatusOrdinal int
-			c.Receive(ctx, &minStatusOrdinal)
+			var minStatus MinimumStatus
+			c.Receive(ctx, &minStatus)
+
+			signalEnsureMinimumStatus(ctx, minStatus, &customer)
+		})
+
+	// signal handler for a guest accepting their invitation
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalAcceptInvitation),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var request AcceptInvitationRequest
+			c.Receive(ctx, &request)
+
+			err := validateAcceptInvitation(request, customer, workflow.Now(ctx))
+			if err != nil {
+				logger.Warn("Ignoring invalid invitation acceptance.", "GuestID", request.GuestID, "Error", err)
+				return
+			}
+			err = signalAcceptInvitation(ctx, request, &customer)
+			if isCorruptGuestState(err) {
+				errSignal = err
+			} else if err != nil {
+				logger.Warn("Unable to enroll guest.", "GuestID", request.GuestID, "Error", err)
+			}
+		})
+
+	// signal handler for guests reporting their live status
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalGuestStatus),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var status GuestStatus
+			c.Receive(ctx, &status)
+
+			if !customer.updateGuestStatus(status) {
+				logger.Info("Ignoring status from a customer that isn't a guest.", "GuestID", status.GuestID)
+			}
+		})
+
+	// signal handler for removing a guest
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalRevokeGuest),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var guestID string
+			c.Receive(ctx, &guestID)
+
+			signalRevokeGuest(ctx, guestID, &customer)
+		})
+
+	// signal handler for a host revoking the status they sponsored
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalRevokeSponsorship),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var sponsorID string
+			c.Receive(ctx, &sponsorID)
+
+			signalRevokeSponsorship(ctx, sponsorID, &customer)
+		})
+
+	// signal handler for redeeming points for a reward
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalRedeemReward),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var request RedeemRequest
+			c.Receive(ctx, &request)
+
+			err := signalRedeemReward(ctx, request, &customer)
+			if err != nil {
+				logger.Warn("Unable to redeem reward.", "Request", request, "Error", err)
+			}
+		})
+
+	// signal handler for consuming a status benefit
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalUseBenefit),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var request UseBenefitRequest
+			c.Receive(ctx, &request)
+
+			err := signalUseBenefit(ctx, request, &customer)
+			if err != nil {
+				logger.Warn("Unable to use benefit.", "Request", request, "Error", err)
+			}
+		})
+
+	// signal handler for changing how the customer is contacted
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalUpdateContact),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var profile ContactProfile
+			c.Receive(ctx, &profile)
+
+			err := signalUpdateContact(ctx, profile, &customer)
+			if err != nil {
+				logger.Warn("Unable to update contact profile.", "Error", err)
+			}
+		})
+
+	// signal handler for suspending the account, e.g. for fraud review
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalSuspendAccount),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var request AccountChangeRequest
+			c.Receive(ctx, &request)
+
+			err := signalSuspendAccount(ctx, request, &customer)
+			if err != nil {
+				logger.Warn("Unable to suspend account.", "Error", err)
+			}
+		})
+
+	// signal handler for reactivating a suspended or closed-pending account
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalReactivateAccount),
+		func(c workflow.ReceiveChannel, _ bool) {
+			var request AccountChangeRequest
+			c.Receive(ctx, &request)
 
-			signalEnsureMinimumStatus(ctx, minStatusOrdinal, &customer)
+			err := signalReactivateAccount(ctx, request, &customer)
+			if err != nil {
+				logger.Warn("Unable to reactivate account.", "Error", err)
+			}
 		})
 
 	// signal handler for canceling account
 	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalCancelAccount),
 		func(c workflow.ReceiveChannel, _ bool) {
-			// nothing to receive, but need this to "handle" signal
-			c.Receive(ctx, nil)
+			// the request is optional; older callers send nothing
+			var request CancelAccountRequest
+			c.Receive(ctx, &request)
 
-			signalCancelAccount(ctx, &customer)
+			err := signalCancelAccount(ctx, request, &customer)
+			if err != nil {
+				logger.Warn("Unable to cancel account.", "Error", err)
+			}
 		})
 
 	// handle Temporal Server cancellation requests
@@ -108,12 +304,12 @@ func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCus
 		})
 
 	// query handler for status level, etc
-	err := workflow.SetQueryHandler(ctx, QueryGetStatus,
+	err = workflow.SetQueryHandler(ctx, QueryGetStatus,
 		func() (GetStatusResponse, error) {
 			return queryGetStatus(ctx, customer)
 		})
 	if err != nil {
-		return fmt.Errorf("unable to register '%v' query handler: %w", QueryGetStatus, err)
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetStatus, err)
 	}
 
 	// query handler for guest list
@@ -122,161 +318,1416 @@ func CustomerLoyaltyWorkflow(ctx workflow.Context, customer CustomerInfo, newCus
 			return queryGetGuests(ctx, customer)
 		})
 	if err != nil {
-		return fmt.Errorf("unable to register '%v' query handler: %w", QueryGetGuests, err)
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetGuests, err)
+	}
+
+	// query handler for remaining benefit entitlements
+	err = workflow.SetQueryHandler(ctx, QueryGetEntitlements,
+		func() ([]Entitlement, error) {
+			return queryGetEntitlements(ctx, customer)
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetEntitlements, err)
+	}
+
+	// query handler for recent points transactions
+	err = workflow.SetQueryHandler(ctx, QueryGetPointsHistory,
+		func() ([]PointsHistoryEntry, error) {
+			return queryGetPointsHistory(ctx, customer)
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetPointsHistory, err)
+	}
+
+	// query handler for guests with their invitation state and live status
+	err = workflow.SetQueryHandler(ctx, QueryGetGuestDetails,
+		func() ([]GuestRecord, error) {
+			return queryGetGuestDetails(ctx, customer)
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetGuestDetails, err)
+	}
+
+	// query handler for pending and accepted guest invitations
+	err = workflow.SetQueryHandler(ctx, QueryGetInvitations,
+		func() ([]GuestInvitation, error) {
+			return queryGetInvitations(ctx, customer)
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetInvitations, err)
+	}
+
+	// query handler for the customer's contact profile
+	err = workflow.SetQueryHandler(ctx, QueryGetContact,
+		func() (ContactProfile, error) {
+			return customer.Contact, nil
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' query handler: %w", QueryGetContact, err)
+	}
+
+	// update handler for adding points; a synchronous alternative to SignalAddPoints that reports the new status
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateAddPoints,
+		func(ctx workflow.Context, transaction PointsTransaction) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			signalAddPoints(workflow.WithActivityOptions(ctx, ao), transaction, &customer)
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, transaction PointsTransaction) error {
+				if transaction.Timestamp.IsZero() {
+					transaction.Timestamp = workflow.Now(ctx)
+				}
+				return validateAddPoints(transaction, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateAddPoints, err)
+	}
+
+	// update handler for purchases; a synchronous alternative to SignalPurchase
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdatePurchase,
+		func(ctx workflow.Context, purchase Purchase) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalPurchase(workflow.WithActivityOptions(ctx, ao), purchase, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(purchase Purchase) error {
+				return validatePurchase(purchase, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdatePurchase, err)
+	}
+
+	// update handler for inviting a guest; a synchronous alternative to SignalInviteGuest
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateInviteGuest,
+		func(ctx workflow.Context, request InviteGuestRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalInviteGuest(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request InviteGuestRequest) error {
+				return validateInviteGuest(request, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateInviteGuest, err)
+	}
+
+	// update handler for accepting an invitation; a synchronous alternative to SignalAcceptInvitation
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateAcceptInvitation,
+		func(ctx workflow.Context, request AcceptInvitationRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalAcceptInvitation(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request AcceptInvitationRequest) error {
+				return validateAcceptInvitation(request, customer, workflow.Now(ctx))
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateAcceptInvitation, err)
+	}
+
+	// update handler for removing a guest; a synchronous alternative to SignalRevokeGuest
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateRevokeGuest,
+		func(ctx workflow.Context, guestID string) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			signalRevokeGuest(workflow.WithActivityOptions(ctx, ao), guestID, &customer)
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(guestID string) error {
+				return validateRevokeGuest(guestID, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateRevokeGuest, err)
+	}
+
+	// update handler for redeeming points; a synchronous alternative to SignalRedeemReward
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateRedeemReward,
+		func(ctx workflow.Context, request RedeemRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalRedeemReward(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request RedeemRequest) error {
+				return validateRedeemReward(request, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateRedeemReward, err)
+	}
+
+	// update handler for consuming a benefit; a synchronous alternative to SignalUseBenefit
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateUseBenefit,
+		func(ctx workflow.Context, request UseBenefitRequest) ([]Entitlement, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalUseBenefit(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return nil, err
+			}
+			return queryGetEntitlements(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request UseBenefitRequest) error {
+				return validateUseBenefit(request, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateUseBenefit, err)
+	}
+
+	// update handler for changing the contact profile; a synchronous alternative to SignalUpdateContact
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateContact,
+		func(ctx workflow.Context, profile ContactProfile) (ContactProfile, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalUpdateContact(workflow.WithActivityOptions(ctx, ao), profile, &customer)
+			return customer.Contact, err
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(profile ContactProfile) error {
+				return validateUpdateContact(profile, customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateContact, err)
+	}
+
+	// update handler for suspending the account; a synchronous alternative to SignalSuspendAccount
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateSuspendAccount,
+		func(ctx workflow.Context, request AccountChangeRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalSuspendAccount(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request AccountChangeRequest) error {
+				return validateSuspendAccount(customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateSuspendAccount, err)
+	}
+
+	// update handler for reactivating the account; a synchronous alternative to SignalReactivateAccount
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateReactivateAccount,
+		func(ctx workflow.Context, request AccountChangeRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalReactivateAccount(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request AccountChangeRequest) error {
+				return validateReactivateAccount(customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateReactivateAccount, err)
+	}
+
+	// update handler for canceling the account; a synchronous alternative to SignalCancelAccount
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateCancelAccount,
+		func(ctx workflow.Context, request CancelAccountRequest) (GetStatusResponse, error) {
+			inFlightUpdates++
+			defer func() { inFlightUpdates-- }()
+
+			err := signalCancelAccount(workflow.WithActivityOptions(ctx, ao), request, &customer)
+			if err != nil {
+				return GetStatusResponse{}, err
+			}
+			return queryGetStatus(ctx, customer)
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(request CancelAccountRequest) error {
+				return validateCancelAccount(customer)
+			},
+		})
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("unable to register '%v' update handler: %w", UpdateCancelAccount, err)
+	}
+
+	// timer for the next points expiration warning or expiry. Pending expirations live in CustomerInfo, so a new run
+	// picks them back up after continue-as-new.
+	expirationTimerPending := false
+	scheduleExpirationTimer := func() {
+		next := customer.nextExpirationEvent()
+		if next.IsZero() {
+			return
+		}
+		expirationTimerPending = true
+		selector.AddFuture(workflow.NewTimer(ctx, next.Sub(workflow.Now(ctx))),
+			func(f workflow.Future) {
+				expirationTimerPending = false
+				err := f.Get(ctx, nil)
+				if err != nil {
+					logger.Warn("Points expiration timer failed.", "Error", err)
+					return
+				}
+
+				processPointsExpiration(ctx, &customer)
+			})
+	}
+
+	// timer for the end of the current qualification period, when status is re-evaluated
+	requalificationTimerPending := false
+	scheduleRequalificationTimer := func() {
+		requalificationTimerPending = true
+		selector.AddFuture(workflow.NewTimer(ctx, customer.qualificationPeriodEnd().Sub(workflow.Now(ctx))),
+			func(f workflow.Future) {
+				requalificationTimerPending = false
+				err := f.Get(ctx, nil)
+				if err != nil {
+					logger.Warn("Requalification timer failed.", "Error", err)
+					return
+				}
+
+				requalify(ctx, &customer)
+			})
+	}
+
+	// timer for the next pending guest invitation to expire
+	invitationTimerPending := false
+	scheduleInvitationTimer := func() {
+		next := customer.nextInvitationExpiry()
+		if next.IsZero() {
+			return
+		}
+		invitationTimerPending = true
+		selector.AddFuture(workflow.NewTimer(ctx, next.Sub(workflow.Now(ctx))),
+			func(f workflow.Future) {
+				invitationTimerPending = false
+				err := f.Get(ctx, nil)
+				if err != nil {
+					logger.Warn("Guest invitation timer failed.", "Error", err)
+					return
+				}
+
+				processInvitationExpiry(ctx, &customer)
+			})
+	}
+
+	// timer for a closed-pending account to close for good. Reactivating the account leaves the timer to fire
+	// harmlessly.
+	closingTimerPending := false
+	scheduleClosingTimer := func() {
+		if customer.Account.State != AccountStateClosedPending {
+			return
+		}
+		closingTimerPending = true
+		selector.AddFuture(workflow.NewTimer(ctx, customer.Account.ClosesAt.Sub(workflow.Now(ctx))),
+			func(f workflow.Future) {
+				closingTimerPending = false
+				err := f.Get(ctx, nil)
+				if err != nil {
+					logger.Warn("Account closing timer failed.", "Error", err)
+					return
+				}
+
+				if customer.Account.State == AccountStateClosedPending &&
+					!workflow.Now(ctx).Before(customer.Account.ClosesAt) {
+					closeAccount(ctx, customer.PendingCancellation, &customer)
+				}
+			})
+	}
+
+	// timer for the end of the digest's quiet period. Each new notification pushes DigestDueAt back, so when the timer
+	// fires early the loop schedules another for the new due time.
+	digestTimerPending := false
+	scheduleDigestTimer := func() {
+		if len(customer.PendingDigest) == 0 {
+			return
+		}
+		digestTimerPending = true
+		selector.AddFuture(workflow.NewTimer(ctx, customer.DigestDueAt.Sub(workflow.Now(ctx))),
+			func(f workflow.Future) {
+				digestTimerPending = false
+				err := f.Get(ctx, nil)
+				if err != nil {
+					logger.Warn("Digest timer failed.", "Error", err)
+					return
+				}
+
+				if !workflow.Now(ctx).Before(customer.DigestDueAt) {
+					flushDigest(ctx, &customer)
+				}
+			})
 	}
 
 	// Block on everything. Continue-As-New on history length; size of activities in this workflow are small enough
 	// that we'll hit the length thresholds well before any size threshold.
 	logger.Info("Waiting for new signals")
-	for customer.AccountActive && info.GetCurrentHistoryLength() < EventsThreshold && !workflowCanceled {
+	for customer.accountOpen() && info.GetCurrentHistoryLength() < EventsThreshold && !workflowCanceled {
+		if !expirationTimerPending {
+			scheduleExpirationTimer()
+		}
+		if !requalificationTimerPending && customer.annualRequalification {
+			scheduleRequalificationTimer()
+		}
+		if !invitationTimerPending {
+			scheduleInvitationTimer()
+		}
+		if !digestTimerPending {
+			scheduleDigestTimer()
+		}
+		if !closingTimerPending {
+			scheduleClosingTimer()
+		}
 		selector.Select(ctx)
 
 		if errSignal != nil {
 			logger.Error("Unrecoverable error in handling a signal.", "Error", errSignal)
-			return errSignal
+			return CustomerInfo{}, errSignal
 		}
+		reportToSponsor(ctx, &customer)
+	}
+	reportToSponsor(ctx, &customer)
+
+	// Let any in-flight updates finish so their callers get a result.
+	err = workflow.Await(ctx, func() bool { return inFlightUpdates == 0 })
+	if err != nil {
+		logger.Warn("Interrupted while waiting for in-flight updates.", "Error", err)
 	}
 
 	// here because of events threshold, but account still active? Continue-As-New
-	if customer.AccountActive && !workflowCanceled {
+	if customer.accountOpen() && !workflowCanceled {
 		logger.Info("Account still active, but hit continue-as-new threshold; Continuing-As-New.", "Customer", customer.CustomerID)
 		// Drain signals before continuing-as-new
 		for selector.HasPending() {
 			selector.Select(ctx)
 		}
-		return workflow.NewContinueAsNewError(ctx, CustomerLoyaltyWorkflow, customer, false)
+		return CustomerInfo{}, workflow.NewContinueAsNewError(ctx, CustomerLoyaltyWorkflow, customer, false)
 	}
 
 	logger.Info("Loyalty workflow completed.", "Customer", customer, "WorkflowCanceled", workflowCanceled)
 	if workflowCanceled {
-		return ctx.Err()
+		// nothing will be left to send what's waiting once this run ends
+		disconnectedCtx, _ := workflow.NewDisconnectedContext(ctx)
+		flushDigest(disconnectedCtx, &customer)
+		return CustomerInfo{}, ctx.Err()
 	}
-	return nil
+	return customer, nil
 }
 
 // CustomerWorkflowID generates a Workflow ID based on the given customer ID.
 func CustomerWorkflowID(customerID string) string {
-	return "customer-" + customerID
+	return CustomerWorkflowIDPrefix + customerID
 }
 
-func signalAddPoints(ctx workflow.Context, pointsToAdd int, customer *CustomerInfo) {
-	logger := workflow.GetLogger(ctx)
-	var activities Activities
+// notify sends the customer a notification, or holds it for their digest if it's low priority and the program
+// batches notifications.
+func notify(ctx workflow.Context, customer *CustomerInfo, email Email) error {
+	priority := email.Template.priority()
+	if priority == PriorityLow && customer.Program.DigestQuietPeriod > 0 {
+		queueDigest(ctx, customer, email)
+		return nil
+	}
 
-	logger.Info("Adding points to customer account.", "PointsAdded", pointsToAdd)
+	err := sendNotification(ctx, customer, email)
+	if priority == PriorityCritical {
+		flushDigest(ctx, customer)
+	}
+	return err
+}
 
-	currentStatusOrd := StatusLevelForPoints(customer.LoyaltyPoints).Ordinal
-	customer.LoyaltyPoints += pointsToAdd
-	newStatusOrd := StatusLevelForPoints(customer.LoyaltyPoints).Ordinal
+// sendNotification sends the customer a notification on the channels their contact profile allows. Runs that
+// predate the notification router keep using SendEmail, addressed to the customer's email.
+func sendNotification(ctx workflow.Context, customer *CustomerInfo, email Email) error {
+	return sendNotificationTo(ctx, customer, customer.CustomerID, customer.Contact, email)
+}
 
-	statusChange := newStatusOrd - currentStatusOrd
+// sendNotificationTo sends a notification from the customer's workflow to someone else, such as an invited guest.
+func sendNotificationTo(ctx workflow.Context, customer *CustomerInfo, recipientID string, contact ContactProfile,
+	email Email) error {
+	var activities Activities
+	key := notificationKey(ctx, customer, email.Template)
 
-	if statusChange > 0 {
-		err := workflow.ExecuteActivity(ctx, activities.SendEmail,
-			fmt.Sprintf(emailPromoted, StatusLevelForPoints(customer.LoyaltyPoints).Name)).
-			Get(ctx, nil)
-		if err != nil {
-			logger.Error("Error running SendEmail activity for status promotion.", "Error", err)
-		}
-	} else if statusChange < 0 {
-		err := workflow.ExecuteActivity(ctx, activities.SendEmail,
-			fmt.Sprintf(emailDemoted, StatusLevelForPoints(customer.LoyaltyPoints).Name)).
-			Get(ctx, nil)
-		if err != nil {
-			logger.Error("Error running SendEmail activity for status demotion.", "Error", err)
-		}
+	version := workflow.GetVersion(ctx, "notification-router", workflow.DefaultVersion, 1)
+	if version == workflow.DefaultVersion {
+		email.To = contact.Email
+		email.Locale = contact.Locale
+		email.IdempotencyKey = key
+		return workflow.ExecuteActivity(ctx, activities.SendEmail, email).Get(ctx, nil)
+	}
+
+	notification := Notification{
+		CustomerID:     recipientID,
+		Contact:        contact,
+		Template:       email.Template,
+		Params:         email.Params,
+		IdempotencyKey: key,
 	}
+	return workflow.ExecuteActivity(ctx, activities.SendNotification, notification).Get(ctx, nil)
 }
 
-func signalInviteGuest(ctx workflow.Context, guestID string, customer *CustomerInfo) error {
-	logger := workflow.GetLogger(ctx)
-	var activities Activities
+// notificationKey identifies the next notification from this run: the workflow and run IDs, the notification's
+// position in the run and its template. It's the same on every attempt of the activity, and on replay.
+func notificationKey(ctx workflow.Context, customer *CustomerInfo, template TemplateName) string {
+	info := workflow.GetInfo(ctx)
+	customer.notificationsSent++
+	return fmt.Sprintf("%v/%v/%v/%v", info.WorkflowExecution.ID, info.WorkflowExecution.RunID,
+		customer.notificationsSent, template)
+}
 
-	var emailToSend string
+func signalAddPoints(ctx workflow.Context, transaction PointsTransaction, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
 
-	logger.Info("Checking to see if customer has enough status to allow for a guest invite.", "Customer", customer)
-	if len(customer.Guests) < StatusLevelForPoints(customer.LoyaltyPoints).GuestsAllowed {
-		logger.Info("Customer is allowed to invite guests. Attempting to invite.",
-			"GuestID", guestID)
+	if err := customer.checkPointsChange(transaction.Amount > 0); err != nil {
+		logger.Info("Ignoring points for an account that isn't active.", "Transaction", transaction,
+			"AccountState", customer.Account.State)
+		return
+	}
 
-		guest := CustomerInfo{
-			CustomerID:    guestID,
-			AccountActive: true,
-			LoyaltyPoints: StatusLevelForPoints(customer.LoyaltyPoints).Previous().MinimumPoints,
+	if transaction.ID != "" {
+		if customer.hasSeenTransaction(string(transaction.Source), transaction.ID) {
+			logger.Info("Ignoring duplicate points transaction.", "TransactionID", transaction.ID)
+			return
 		}
+		customer.recordTransaction(string(transaction.Source), transaction.ID)
+	}
 
-		customer.addGuest(guestID)
-		var inviteResult GuestInviteResult
-		err := workflow.ExecuteActivity(ctx, activities.StartGuestWorkflow, guest).
-			Get(ctx, &inviteResult)
-		if err != nil {
-			return fmt.Errorf("could not signal-with-start guest/child workflow for guest ID '%v': %w", guestID, err)
-		}
+	if transaction.Timestamp.IsZero() {
+		transaction.Timestamp = workflow.Now(ctx)
+	}
+	entry := customer.credit(transaction)
+	customer.recordHistory(entry)
 
-		if inviteResult == GuestAlreadyCanceled {
-			emailToSend = emailGuestCanceled
-		} else {
-			emailToSend = emailGuestInvited
-		}
+	pointsToAdd := entry.PointsCredited
+	logger.Info("Adding points to customer account.", "PointsAdded", pointsToAdd, "Transaction", transaction)
+
+	customer.LoyaltyPoints += pointsToAdd
+	if pointsToAdd > 0 {
+		customer.earnBatch(pointsToAdd, workflow.Now(ctx))
 	} else {
-		logger.Info("Customer does not have sufficient status to invite more guests.")
-		emailToSend = emailInsufficientPoints
+		customer.spendBatches(-pointsToAdd)
+	}
+	if transaction.NonQualifying {
+		return
 	}
 
-	err := workflow.ExecuteActivity(ctx, activities.SendEmail, emailToSend).Get(ctx, nil)
-	if err != nil {
-		logger.Error("Error running SendEmail activity for guest invite.", "Error", err)
+	// Losing qualifying points never demotes immediately; that waits for requalification. Runs that predate
+	// requalification start a new period as points arrive instead, and follow their qualifying points both ways.
+	previousStatus := customer.status()
+	if !customer.annualRequalification {
+		customer.advanceQualificationPeriod(workflow.Now(ctx))
+	}
+	customer.QualifyingPoints += pointsToAdd
+	if customer.QualifyingPoints < 0 {
+		customer.QualifyingPoints = 0
+	}
+	if customer.annualRequalification {
+		customer.promote()
+	} else {
+		customer.StatusOrdinal = customer.Program.StatusLevelForPoints(customer.QualifyingPoints).Ordinal
 	}
 
-	return nil
+	sendStatusChangeEmail(ctx, previousStatus, customer)
 }
 
-func signalEnsureMinimumStatus(ctx workflow.Context, minStatusOrdinal int, customer *CustomerInfo) {
-	var activities Activities
+// signalPurchase converts the purchase to a points transaction with the CalculateAccrual activity, then credits it.
+func signalPurchase(ctx workflow.Context, purchase Purchase, customer *CustomerInfo) error {
 	logger := workflow.GetLogger(ctx)
+	var activities Activities
 
-	if StatusLevelForPoints(customer.LoyaltyPoints).Ordinal < minStatusOrdinal {
-		newStatus := StatusLevels[minStatusOrdinal]
-		customer.LoyaltyPoints = newStatus.MinimumPoints
+	if purchase.ID != "" && customer.hasSeenTransaction(string(SourcePurchase), purchase.ID) {
+		logger.Info("Ignoring duplicate purchase.", "PurchaseID", purchase.ID)
+		return nil
+	}
 
-		emailBody := fmt.Sprintf(emailPromoted, newStatus.Name)
-		err := workflow.ExecuteActivity(ctx, activities.SendEmail, emailBody).Get(ctx, nil)
+	// Runs started before refunds named the purchase they refund credited any negative purchase.
+	checkRefunds := workflow.GetVersion(ctx, "purchase-refunds", workflow.DefaultVersion, 1) == 1
+	if checkRefunds {
+		err := purchase.validateRefund()
 		if err != nil {
-			logger.Error("Error running SendEmail activity for promotion.", "Error", err)
+			return err
 		}
 	}
-}
-
-func signalCancelAccount(ctx workflow.Context, customer *CustomerInfo) {
-	logger := workflow.GetLogger(ctx)
-	var activities Activities
 
-	customer.AccountActive = false
-	err := workflow.ExecuteActivity(ctx, activities.SendEmail, emailCancelAccount).Get(ctx, nil)
+	var transaction PointsTransaction
+	err := workflow.ExecuteActivity(ctx, activities.CalculateAccrual, purchase).Get(ctx, &transaction)
 	if err != nil {
-		logger.Error("Error running SendEmail activity for account cancellation.", "Error", err)
+		return fmt.Errorf("could not calculate points for purchase '%v': %w", purchase.ID, err)
 	}
 
-	logger.Info("Canceled account.", "CustomerID", customer.CustomerID)
-}
+	if transaction.Timestamp.IsZero() {
+		transaction.Timestamp = workflow.Now(ctx)
+	}
+	if checkRefunds && customer.LoyaltyPoints+customer.credit(transaction).PointsCredited < 0 {
+		return fmt.Errorf("refund '%v' would leave a negative balance", purchase.ID)
+	}
 
-func queryGetStatus(ctx workflow.Context, customer CustomerInfo) (GetStatusResponse, error) {
-	logger := workflow.GetLogger(ctx)
+	signalAddPoints(ctx, transaction, customer)
+	return nil
+}
 
-	response := GetStatusResponse{
-		StatusLevel:   *StatusLevelForPoints(customer.LoyaltyPoints),
-		Points:        customer.LoyaltyPoints,
-		AccountActive: customer.AccountActive,
+func validatePurchase(purchase Purchase, customer CustomerInfo) error {
+	// retries are answered by the handler with the original outcome
+	if purchase.ID != "" && customer.hasSeenTransaction(string(SourcePurchase), purchase.ID) {
+		return nil
 	}
-	logger.Info("Got response query.", "Customer", customer, "Response", response)
-
-	return response, nil
+	err := customer.checkPointsChange(purchase.Amount > 0)
+	if err != nil {
+		return err
+	}
+	if purchase.Amount == 0 {
+		return fmt.Errorf("purchase '%v' has no amount", purchase.ID)
+	}
+	if purchase.Currency == "" {
+		return fmt.Errorf("purchase '%v' has no currency", purchase.ID)
+	}
+	return purchase.validateRefund()
 }
 
-func queryGetGuests(ctx workflow.Context, customer CustomerInfo) ([]string, error) {
+func signalAddCampaign(ctx workflow.Context, campaign Campaign, customer *CustomerInfo) {
 	logger := workflow.GetLogger(ctx)
-	guestIDs := customer.Guests
 
-	logger.Info("Got guest list query.", "Guests", guestIDs)
+	err := campaign.Validate()
+	if err != nil {
+		logger.Warn("Ignoring invalid campaign.", "Campaign", campaign, "Error", err)
+		return
+	}
+
+	added := customer.addCampaign(campaign, workflow.Now(ctx))
+	eligible := campaign.eligible(customer)
+	logger.Info("Added campaign.", "CampaignID", campaign.ID, "Eligible", eligible, "Replaced", !added)
+
+	// A campaign that's sent again, e.g. by a retried fan-out, is only announced the first time. Runs started before
+	// this announced it every time.
+	if !added && workflow.GetVersion(ctx, "campaign-announced-once", workflow.DefaultVersion, 1) == 1 {
+		return
+	}
+	if eligible && customer.Contact.MarketingConsent {
+		err := notify(ctx, customer, campaignAnnouncementEmail(campaign))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for campaign announcement.", "Error", err)
+		}
+	}
+}
+
+// signalUpdateContact replaces the customer's contact profile. A new time zone is checked by an activity first.
+func signalUpdateContact(ctx workflow.Context, profile ContactProfile, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+	var activities Activities
+
+	err := validateUpdateContact(profile, *customer)
+	if err != nil {
+		return err
+	}
+
+	if profile.TimeZone != "" && profile.TimeZone != customer.Contact.TimeZone &&
+		workflow.GetVersion(ctx, "time-zone-activity", workflow.DefaultVersion, 1) == 1 {
+		err = workflow.ExecuteActivity(ctx, activities.CheckTimeZone, profile.TimeZone).Get(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("could not update contact profile: %w", err)
+		}
+	}
+	customer.Contact = profile
+	logger.Info("Updated contact profile.", "Channels", profile.channels())
+	return nil
+}
+
+func validateUpdateContact(profile ContactProfile, customer CustomerInfo) error {
+	if !customer.accountOpen() {
+		return fmt.Errorf("account '%v' is closed", customer.CustomerID)
+	}
+	return profile.Validate()
+}
+
+// sendStatusChangeEmail notifies the customer if their status is no longer previousStatus.
+func sendStatusChangeEmail(ctx workflow.Context, previousStatus *StatusLevel, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	newStatus := customer.status()
+	statusChange := newStatus.Ordinal - previousStatus.Ordinal
+
+	if statusChange > 0 {
+		err := notify(ctx, customer, promotedEmail(newStatus.Name))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for status promotion.", "Error", err)
+		}
+	} else if statusChange < 0 {
+		err := notify(ctx, customer, demotedEmail(newStatus.Name))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for status demotion.", "Error", err)
+		}
+	}
+}
+
+func validateAddPoints(transaction PointsTransaction, customer CustomerInfo) error {
+	// retries are answered by the handler with the original outcome
+	if transaction.ID != "" && customer.hasSeenTransaction(string(transaction.Source), transaction.ID) {
+		return nil
+	}
+	err := customer.checkPointsChange(transaction.Amount > 0)
+	if err != nil {
+		return err
+	}
+	if transaction.Amount == 0 {
+		return fmt.Errorf("transaction '%v' has no points", transaction.ID)
+	}
+	if customer.LoyaltyPoints+customer.credit(transaction).PointsCredited < 0 {
+		return fmt.Errorf("transaction '%v' would leave a negative balance", transaction.ID)
+	}
+	return nil
+}
+
+// requalify re-evaluates the customer's status from the qualification period that just ended, then starts a new
+// period. With the program's DemotionSoftLanding, the customer drops at most one level.
+func requalify(ctx workflow.Context, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	previousStatus := customer.status()
+	earned := customer.Program.StatusLevelForPoints(customer.QualifyingPoints)
+	if !customer.advanceQualificationPeriod(workflow.Now(ctx)) {
+		return
+	}
+
+	newOrdinal := earned.Ordinal
+	if customer.Program.DemotionSoftLanding && newOrdinal < previousStatus.Ordinal-1 {
+		newOrdinal = previousStatus.Ordinal - 1
+	}
+	// a sponsorship still in force keeps the status it grants
+	if customer.SponsorID != "" && newOrdinal < customer.SponsoredOrdinal {
+		newOrdinal = customer.SponsoredOrdinal
+	}
+	customer.StatusOrdinal = newOrdinal
+	logger.Info("Requalified customer.", "PreviousStatus", previousStatus.Name, "NewStatus", customer.status().Name)
+
+	var email Email
+	if customer.StatusOrdinal < previousStatus.Ordinal {
+		email = demotedEmail(customer.status().Name)
+	} else if previousStatus.Ordinal > 0 {
+		email = statusRetainedEmail(customer.status().Name)
+	} else {
+		return
+	}
+
+	err := notify(ctx, customer, email)
+	if err != nil {
+		logger.Error("Error running SendNotification activity for requalification.", "Error", err)
+	}
+}
+
+// processPointsExpiration expires any points that are due and warns about points that will expire soon.
+func processPointsExpiration(ctx workflow.Context, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+	now := workflow.Now(ctx)
+
+	expired := customer.expireBatches(now)
+	if expired > 0 {
+		logger.Info("Points expired.", "Points", expired)
+		err := notify(ctx, customer, pointsExpiredEmail(expired))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for points expiration.", "Error", err)
+		}
+	}
+
+	expiring, expiresAt := customer.warnBatches(now)
+	if expiring > 0 {
+		logger.Info("Points expiring soon.", "Points", expiring, "ExpiresAt", expiresAt)
+		err := notify(ctx, customer, pointsExpiringEmail(expiring, expiresAt))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for points expiration warning.", "Error", err)
+		}
+	}
+}
+
+// signalInviteGuest reserves one of the customer's guest slots for the guest. If the program requires guests to
+// accept, the invitation code is sent to the guest's own contact and the host is only told it's on its way, so the
+// host can't accept on the guest's behalf. Otherwise the guest is enrolled straight away.
+func signalInviteGuest(ctx workflow.Context, request InviteGuestRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+	guestID := request.GuestID
+
+	err := validateInviteGuest(request, *customer)
+	if err != nil {
+		return err
+	}
+	err = customer.checkGuestState()
+	if err != nil {
+		return err
+	}
+
+	var emailToSend Email
+
+	logger.Info("Checking to see if customer has enough status to allow for a guest invite.", "Customer", customer)
+	if customer.hasGuest(guestID) && customer.guestAccepted(guestID) {
+		// already holds a slot; make sure they still have the status their host sponsors
+		emailToSend, err = enrollGuest(ctx, guestID, customer)
+		if err != nil {
+			return err
+		}
+	} else if customer.hasGuest(guestID) || customer.benefitRemaining(BenefitGuests) > 0 {
+		logger.Info("Customer is allowed to invite guests. Attempting to invite.",
+			"GuestID", guestID)
+
+		// a pending invitation is reissued with a new code and deadline
+		now := workflow.Now(ctx)
+		customer.addGuest(guestID)
+		customer.removeInvitation(guestID)
+		invitation := GuestInvitation{
+			GuestID:   guestID,
+			State:     InvitationPending,
+			InvitedAt: now,
+		}
+
+		if ttl := customer.Program.GuestInvitationTTL; ttl > 0 {
+			code, err := newInvitationCode(ctx)
+			if err != nil {
+				return failGuestInvite(ctx, guestID, customer, err)
+			}
+			invitation.Code = code
+			invitation.ExpiresAt = now.Add(ttl)
+			customer.Invitations = append(customer.Invitations, invitation)
+
+			err = sendNotificationTo(ctx, customer, guestID, request.Contact,
+				guestInvitationEmail(code, invitation.ExpiresAt))
+			if err != nil {
+				logger.Error("Error running SendNotification activity for guest invitation code.", "Error", err)
+			}
+			emailToSend = invitationSentEmail(guestID, invitation.ExpiresAt)
+		} else {
+			customer.Invitations = append(customer.Invitations, invitation)
+
+			emailToSend, err = enrollGuest(ctx, guestID, customer)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		logger.Info("Customer does not have sufficient status to invite more guests.")
+		emailToSend = insufficientPointsEmail()
+	}
+
+	err = notify(ctx, customer, emailToSend)
+	if err != nil {
+		logger.Error("Error running SendNotification activity for guest invite.", "Error", err)
+	}
+
+	return nil
+}
+
+// enrollGuest starts (or promotes) the guest's own loyalty workflow at the status their host sponsors, marks their
+// invitation accepted, and returns the email to send the host. If the guest can't be started, the failure is
+// recorded, the slot released and the host emailed before the error is returned.
+func enrollGuest(ctx workflow.Context, guestID string, customer *CustomerInfo) (Email, error) {
+	guestStatus := customer.Program.StatusLevels[0]
+	if previous := customer.Program.Previous(customer.status()); previous != nil {
+		guestStatus = previous
+	}
+	guest := CustomerInfo{
+		CustomerID:       guestID,
+		AccountActive:    true,
+		Program:          customer.Program,
+		StatusOrdinal:    guestStatus.Ordinal,
+		SponsorID:        customer.CustomerID,
+		SponsoredOrdinal: guestStatus.Ordinal,
+	}
+
+	inviteResult, err := startGuest(ctx, guest)
+	if err != nil {
+		return Email{}, failGuestInvite(ctx, guestID, customer, err)
+	}
+
+	if invitation := customer.invitation(guestID); invitation != nil && invitation.State == InvitationPending {
+		invitation.State = InvitationAccepted
+		invitation.AcceptedAt = workflow.Now(ctx)
+	}
+
+	switch inviteResult {
+	case GuestAlreadyCanceled:
+		// a closed account can't be sponsored, so the slot is free again
+		customer.failInvitation(guestID, "guest previously closed their account")
+		return guestCanceledEmail(), nil
+	case GuestAlreadyMember:
+		return guestAlreadyMemberEmail(), nil
+	case GuestUpgraded:
+		return guestUpgradedEmail(), nil
+	default:
+		return guestInvitedEmail(), nil
+	}
+}
+
+// startGuest runs the guest's workflow. Programs that opt in start it as an abandoned child of this workflow; others,
+// including every workflow that predates child guests, use the StartGuestWorkflow activity. Guests that already have
+// an execution always go through the activity, which can tell an active member from one who closed their account.
+func startGuest(ctx workflow.Context, guest CustomerInfo) (GuestInviteResult, error) {
+	logger := workflow.GetLogger(ctx)
+	var activities Activities
+
+	if guest.Program.GuestsAsChildWorkflows {
+		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID:            CustomerWorkflowID(guest.CustomerID),
+			WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+			ParentClosePolicy:     enums.PARENT_CLOSE_POLICY_ABANDON,
+		})
+		err := workflow.ExecuteChildWorkflow(childCtx, CustomerLoyaltyWorkflow, guest, true).
+			GetChildWorkflowExecution().Get(ctx, nil)
+		if err == nil {
+			return GuestInvited, nil
+		} else if !temporal.IsWorkflowExecutionAlreadyStartedError(err) {
+			return -1, err
+		}
+		logger.Info("Guest already has a workflow; updating it instead.", "GuestID", guest.CustomerID)
+	}
+
+	var inviteResult GuestInviteResult
+	err := workflow.ExecuteActivity(ctx, activities.StartGuestWorkflow, guest).
+		Get(ctx, &inviteResult)
+	return inviteResult, err
+}
+
+// failGuestInvite records an invitation that couldn't be completed, releases its slot and lets the host know. The
+// returned error wraps the cause as an ErrTypeGuestInviteFailed application error.
+func failGuestInvite(ctx workflow.Context, guestID string, customer *CustomerInfo, cause error) error {
+	logger := workflow.GetLogger(ctx)
+
+	logger.Warn("Guest invitation failed; releasing slot.", "GuestID", guestID, "Error", cause)
+	customer.failInvitation(guestID, cause.Error())
+
+	err := notify(ctx, customer, guestInviteFailedEmail(guestID))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for failed guest invite.", "Error", err)
+	}
+
+	return temporal.NewApplicationErrorWithCause(
+		fmt.Sprintf("could not invite guest '%v'", guestID), ErrTypeGuestInviteFailed, cause)
+}
+
+// signalAcceptInvitation enrolls a guest who has accepted their pending invitation.
+func signalAcceptInvitation(ctx workflow.Context, request AcceptInvitationRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+
+	err := customer.checkGuestState()
+	if err != nil {
+		return err
+	}
+
+	emailToSend, err := enrollGuest(ctx, request.GuestID, customer)
+	if err != nil {
+		return err
+	}
+
+	err = notify(ctx, customer, emailToSend)
+	if err != nil {
+		logger.Error("Error running SendNotification activity for invitation acceptance.", "Error", err)
+	}
+	return nil
+}
+
+func validateAcceptInvitation(request AcceptInvitationRequest, customer CustomerInfo, now time.Time) error {
+	err := customer.checkActive()
+	if err != nil {
+		return err
+	}
+	invitation := customer.invitation(request.GuestID)
+	if invitation == nil || invitation.State != InvitationPending {
+		return fmt.Errorf("no pending invitation for guest '%v'", request.GuestID)
+	}
+	if request.Code != invitation.Code {
+		return fmt.Errorf("invalid invitation code for guest '%v'", request.GuestID)
+	}
+	if !now.Before(invitation.ExpiresAt) {
+		return fmt.Errorf("invitation for guest '%v' expired at %v", request.GuestID, invitation.ExpiresAt)
+	}
+	return nil
+}
+
+// processInvitationExpiry releases the slots held by invitations that weren't accepted in time.
+func processInvitationExpiry(ctx workflow.Context, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	for _, guestID := range customer.expireInvitations(workflow.Now(ctx)) {
+		logger.Info("Guest invitation expired.", "GuestID", guestID)
+
+		err := notify(ctx, customer, invitationExpiredEmail(guestID))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for invitation expiry.", "Error", err)
+		}
+	}
+}
+
+func validateInviteGuest(request InviteGuestRequest, customer CustomerInfo) error {
+	err := customer.checkActive()
+	if err != nil {
+		return err
+	}
+	if request.GuestID == "" {
+		return fmt.Errorf("guest ID is required")
+	}
+	if request.GuestID == customer.CustomerID {
+		return fmt.Errorf("customer '%v' cannot invite themselves", request.GuestID)
+	}
+	return customer.checkGuestContact(request)
+}
+
+func signalEnsureMinimumStatus(ctx workflow.Context, minStatus MinimumStatus, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	newStatus := customer.Program.statusLevelFor(minStatus)
+	if newStatus == nil {
+		logger.Warn("Ignoring minimum status that isn't in this customer's program.", "MinimumStatus", minStatus,
+			"ProgramVersion", customer.Program.Version)
+		return
+	}
+
+	if minStatus.SponsorID != "" {
+		if minStatus.SponsorID != customer.SponsorID {
+			customer.SponsorID = minStatus.SponsorID
+			customer.ReportedToSponsor = GuestStatus{}
+		}
+		customer.SponsoredOrdinal = newStatus.Ordinal
+	}
+
+	if customer.StatusOrdinal < newStatus.Ordinal {
+		customer.StatusOrdinal = newStatus.Ordinal
+
+		err := notify(ctx, customer, promotedEmail(newStatus.Name))
+		if err != nil {
+			logger.Error("Error running SendNotification activity for promotion.", "Error", err)
+		}
+	}
+}
+
+// signalRevokeGuest removes the guest, freeing their slot, and tells the guest's workflow to drop the status this
+// customer sponsored.
+func signalRevokeGuest(ctx workflow.Context, guestID string, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	accepted := customer.guestAccepted(guestID)
+	if !customer.removeGuest(guestID) {
+		logger.Info("Ignoring revocation of unknown guest.", "GuestID", guestID)
+		return
+	}
+	customer.removeInvitation(guestID)
+
+	// a guest who hasn't accepted yet has no status to drop
+	if accepted {
+		revokeSponsorship(ctx, guestID, customer)
+	}
+
+	err := notify(ctx, customer, guestRevokedEmail(guestID))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for guest revocation.", "Error", err)
+	}
+}
+
+func validateRevokeGuest(guestID string, customer CustomerInfo) error {
+	if !customer.hasGuest(guestID) {
+		return fmt.Errorf("'%v' is not a guest of customer '%v'", guestID, customer.CustomerID)
+	}
+	return nil
+}
+
+// revokeSponsorship signals the guest's workflow. A guest whose account has already closed has no status to drop,
+// so failures are only logged.
+func revokeSponsorship(ctx workflow.Context, guestID string, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	err := workflow.SignalExternalWorkflow(ctx, CustomerWorkflowID(guestID), "",
+		SignalRevokeSponsorship, customer.CustomerID).Get(ctx, nil)
+	if err != nil {
+		logger.Warn("Unable to revoke guest's sponsored status.", "GuestID", guestID, "Error", err)
+	}
+}
+
+// reportToSponsor tells a guest's sponsor about any change in the guest's status or account.
+func reportToSponsor(ctx workflow.Context, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	if customer.SponsorID == "" {
+		return
+	}
+	status, changed := customer.sponsorStatus()
+	if !changed {
+		return
+	}
+	status.UpdatedAt = workflow.Now(ctx)
+
+	err := workflow.SignalExternalWorkflow(ctx, CustomerWorkflowID(customer.SponsorID), "",
+		SignalGuestStatus, status).Get(ctx, nil)
+	var unknownErr *temporal.UnknownExternalWorkflowExecutionError
+	if errors.As(err, &unknownErr) {
+		// the sponsor's account has closed, so there's no one left to report to, or to keep status for
+		logger.Info("Sponsor no longer exists; ending sponsorship.", "SponsorID", customer.SponsorID)
+		customer.endSponsorship()
+		return
+	} else if err != nil {
+		logger.Warn("Unable to report status to sponsor.", "SponsorID", customer.SponsorID, "Error", err)
+		return
+	}
+	customer.ReportedToSponsor = status
+}
+
+// signalRevokeSponsorship drops the customer back to the status they've earned themselves, if their current status
+// was sponsored by the given host.
+func signalRevokeSponsorship(ctx workflow.Context, sponsorID string, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	if sponsorID == "" || customer.SponsorID != sponsorID {
+		logger.Info("Ignoring revocation from a host that isn't this customer's sponsor.", "SponsorID", sponsorID)
+		return
+	}
+	customer.endSponsorship()
+
+	earned := customer.Program.StatusLevelForPoints(customer.QualifyingPoints)
+	if earned.Ordinal >= customer.StatusOrdinal {
+		return
+	}
+	customer.StatusOrdinal = earned.Ordinal
+
+	err := notify(ctx, customer, sponsorshipRevokedEmail(earned.Name))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for sponsorship revocation.", "Error", err)
+	}
+}
+
+// signalRedeemReward reserves the reward's points, then fulfills it. If fulfillment fails permanently, the
+// reservation is compensated by refunding the points.
+func signalRedeemReward(ctx workflow.Context, request RedeemRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+	var activities Activities
+
+	// a retry gets the original redemption's outcome, once it has one
+	if request.ID != "" && customer.hasSeenTransaction(transactionsRedeem, request.ID) {
+		logger.Info("Ignoring duplicate redemption.", "RedemptionID", request.ID)
+		err := workflow.Await(ctx, func() bool { return !customer.redemptionPending(request.ID) })
+		if err != nil {
+			return err
+		}
+		return customer.transactionError(transactionsRedeem, request.ID)
+	}
+
+	err := validateRedeemReward(request, *customer)
+	if err != nil {
+		return err
+	}
+	if request.ID != "" {
+		customer.recordTransaction(transactionsRedeem, request.ID)
+	}
+
+	reward := customer.Program.RewardForID(request.RewardID)
+	redemption := Redemption{
+		ID:          request.ID,
+		RewardID:    reward.ID,
+		Points:      reward.Cost,
+		RequestedAt: workflow.Now(ctx),
+	}
+	if redemption.ID == "" {
+		redemption.ID = fmt.Sprintf("%v-%v", reward.ID, redemption.RequestedAt.UnixNano())
+	}
+
+	logger.Info("Reserving points for reward.", "Redemption", redemption)
+	customer.reservePoints(redemption)
+
+	fulfillmentCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 5,
+			InitialInterval: time.Second * 5,
+		},
+	})
+	err = workflow.ExecuteActivity(fulfillmentCtx, activities.FulfillReward, customer.CustomerID, redemption).
+		Get(ctx, nil)
+	if err != nil {
+		logger.Error("Reward fulfillment failed; refunding points.", "Redemption", redemption, "Error", err)
+		customer.releaseRedemption(redemption.ID, true)
+
+		emailErr := notify(ctx, customer, redemptionFailedEmail(reward.Name, reward.Cost))
+		if emailErr != nil {
+			logger.Error("Error running SendNotification activity for failed redemption.", "Error", emailErr)
+		}
+		err = fmt.Errorf("could not fulfill reward '%v': %w", reward.ID, err)
+		if request.ID != "" {
+			customer.failTransaction(transactionsRedeem, request.ID, err)
+		}
+		return err
+	}
+
+	customer.releaseRedemption(redemption.ID, false)
+	err = notify(ctx, customer, rewardRedeemedEmail(reward.Name, reward.Cost))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for redemption.", "Error", err)
+	}
+
+	return nil
+}
+
+func validateRedeemReward(request RedeemRequest, customer CustomerInfo) error {
+	// retries are answered by the handler with the original outcome
+	if request.ID != "" && customer.hasSeenTransaction(transactionsRedeem, request.ID) {
+		return nil
+	}
+	err := customer.checkActive()
+	if err != nil {
+		return err
+	}
+	reward := customer.Program.RewardForID(request.RewardID)
+	if reward == nil {
+		return fmt.Errorf("unknown reward '%v'", request.RewardID)
+	}
+	if customer.LoyaltyPoints < reward.Cost {
+		return fmt.Errorf("reward '%v' costs %v points but only %v are available",
+			reward.ID, reward.Cost, customer.LoyaltyPoints)
+	}
+	return nil
+}
+
+func signalUseBenefit(ctx workflow.Context, request UseBenefitRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+
+	if request.ID != "" && customer.hasSeenTransaction(transactionsBenefit, request.ID) {
+		logger.Info("Ignoring duplicate benefit use.", "RequestID", request.ID)
+		return nil
+	}
+
+	err := validateUseBenefit(request, *customer)
+	if err != nil {
+		return err
+	}
+	if request.ID != "" {
+		customer.recordTransaction(transactionsBenefit, request.ID)
+	}
+
+	customer.useBenefit(request.Benefit)
+	logger.Info("Used benefit.", "Benefit", request.Benefit, "Remaining", customer.benefitRemaining(request.Benefit))
+
+	err = notify(ctx, customer, benefitUsedEmail(request.Benefit, customer.benefitRemaining(request.Benefit)))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for benefit use.", "Error", err)
+	}
+	return nil
+}
+
+func validateUseBenefit(request UseBenefitRequest, customer CustomerInfo) error {
+	// retries are answered by the handler with the original outcome
+	if request.ID != "" && customer.hasSeenTransaction(transactionsBenefit, request.ID) {
+		return nil
+	}
+	err := customer.checkActive()
+	if err != nil {
+		return err
+	}
+	if request.Benefit == BenefitGuests {
+		return fmt.Errorf("guest slots are used by inviting a guest")
+	}
+	if customer.benefitRemaining(request.Benefit) <= 0 {
+		return fmt.Errorf("no '%v' remaining at '%v' status", request.Benefit, customer.status().Name)
+	}
+	return nil
+}
+
+// signalSuspendAccount stops the account earning and redeeming until it's reactivated. Its points and status are
+// kept.
+func signalSuspendAccount(ctx workflow.Context, request AccountChangeRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+
+	err := validateSuspendAccount(*customer)
+	if err != nil {
+		return err
+	}
+	customer.setAccountState(AccountStateSuspended, request.Reason, workflow.Now(ctx))
+	logger.Info("Suspended account.", "CustomerID", customer.CustomerID, "Reason", request.Reason)
+
+	err = notify(ctx, customer, accountSuspendedEmail())
+	if err != nil {
+		logger.Error("Error running SendNotification activity for account suspension.", "Error", err)
+	}
+	return nil
+}
+
+func validateSuspendAccount(customer CustomerInfo) error {
+	return customer.validateTransition(AccountStateSuspended)
+}
+
+// signalReactivateAccount makes a suspended or closed-pending account active again. A pending cancellation is
+// dropped, so nothing it would have done happens.
+func signalReactivateAccount(ctx workflow.Context, request AccountChangeRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+
+	err := validateReactivateAccount(*customer)
+	if err != nil {
+		return err
+	}
+	previous := customer.Account.State
+	customer.setAccountState(AccountStateActive, request.Reason, workflow.Now(ctx))
+	customer.PendingCancellation = CancelAccountRequest{}
+	logger.Info("Reactivated account.", "CustomerID", customer.CustomerID, "PreviousState", previous,
+		"Reason", request.Reason)
+
+	err = notify(ctx, customer, accountReactivatedEmail())
+	if err != nil {
+		logger.Error("Error running SendNotification activity for account reactivation.", "Error", err)
+	}
+	return nil
+}
+
+func validateReactivateAccount(customer CustomerInfo) error {
+	return customer.validateTransition(AccountStateActive)
+}
+
+// signalCancelAccount closes the account, after the program's CancellationGracePeriod if it has one. Until then the
+// account is closed-pending and can be reactivated.
+func signalCancelAccount(ctx workflow.Context, request CancelAccountRequest, customer *CustomerInfo) error {
+	logger := workflow.GetLogger(ctx)
+
+	err := validateCancelAccount(*customer)
+	if err != nil {
+		return err
+	}
+	gracePeriod := customer.Program.CancellationGracePeriod
+	if gracePeriod == 0 {
+		closeAccount(ctx, request, customer)
+		return nil
+	}
+
+	now := workflow.Now(ctx)
+	customer.setAccountState(AccountStateClosedPending, request.Reason, now)
+	customer.Account.ClosesAt = now.Add(gracePeriod)
+	customer.PendingCancellation = request
+	logger.Info("Account closing.", "CustomerID", customer.CustomerID, "ClosesAt", customer.Account.ClosesAt)
+
+	err = notify(ctx, customer, accountClosingEmail(customer.Account.ClosesAt))
+	if err != nil {
+		logger.Error("Error running SendNotification activity for account closing.", "Error", err)
+	}
+	return nil
+}
+
+func validateCancelAccount(customer CustomerInfo) error {
+	if customer.Program.CancellationGracePeriod == 0 {
+		return customer.validateTransition(AccountStateClosed)
+	}
+	return customer.validateTransition(AccountStateClosedPending)
+}
+
+// closeAccount closes the account for good, which ends the workflow.
+func closeAccount(ctx workflow.Context, request CancelAccountRequest, customer *CustomerInfo) {
+	logger := workflow.GetLogger(ctx)
+
+	customer.setAccountState(AccountStateClosed, request.Reason, workflow.Now(ctx))
+	customer.PendingCancellation = CancelAccountRequest{}
+	if request.RevokeGuests {
+		for _, guestID := range customer.Guests {
+			if customer.guestAccepted(guestID) {
+				revokeSponsorship(ctx, guestID, customer)
+			}
+		}
+		customer.Guests = nil
+		customer.Invitations = nil
+	}
+
+	err := notify(ctx, customer, cancelAccountEmail())
+	if err != nil {
+		logger.Error("Error running SendNotification activity for account cancellation.", "Error", err)
+	}
+
+	logger.Info("Canceled account.", "CustomerID", customer.CustomerID)
+}
+
+func queryGetStatus(ctx workflow.Context, customer CustomerInfo) (GetStatusResponse, error) {
+	logger := workflow.GetLogger(ctx)
+
+	response := GetStatusResponse{
+		ProgramVersion:         customer.Program.Version,
+		StatusLevel:            *customer.status(),
+		Points:                 customer.LoyaltyPoints,
+		QualifyingPoints:       customer.QualifyingPoints,
+		QualificationPeriodEnd: customer.qualificationPeriodEnd(),
+		AccountActive:          customer.AccountActive,
+		Account:                customer.Account,
+	}
+	logger.Info("Got response query.", "Customer", customer, "Response", response)
+
+	return response, nil
+}
+
+func queryGetEntitlements(ctx workflow.Context, customer CustomerInfo) ([]Entitlement, error) {
+	logger := workflow.GetLogger(ctx)
+	entitlements := customer.entitlements()
+
+	logger.Info("Got entitlements query.", "Entitlements", entitlements)
+	return entitlements, nil
+}
+
+func queryGetPointsHistory(ctx workflow.Context, customer CustomerInfo) ([]PointsHistoryEntry, error) {
+	logger := workflow.GetLogger(ctx)
+	history := customer.PointsHistory
+
+	logger.Info("Got points history query.", "Entries", len(history))
+	return history, nil
+}
+
+func queryGetGuests(ctx workflow.Context, customer CustomerInfo) ([]string, error) {
+	logger := workflow.GetLogger(ctx)
+	guestIDs := customer.Guests
+
+	logger.Info("Got guest list query.", "Guests", guestIDs)
 	return guestIDs, nil
 }
+
+// queryGetInvitations returns the customer's guest invitations. Codes are left out; only the guest should know them.
+func queryGetInvitations(ctx workflow.Context, customer CustomerInfo) ([]GuestInvitation, error) {
+	logger := workflow.GetLogger(ctx)
+
+	invitations := make([]GuestInvitation, 0, len(customer.Invitations))
+	for _, invitation := range customer.Invitations {
+		invitation.Code = ""
+		invitations = append(invitations, invitation)
+	}
+
+	logger.Info("Got invitations query.", "Invitations", invitations)
+	return invitations, nil
+}
+
+func queryGetGuestDetails(ctx workflow.Context, customer CustomerInfo) ([]GuestRecord, error) {
+	logger := workflow.GetLogger(ctx)
+	records := customer.guestRecords()
+
+	logger.Info("Got guest details query.", "Guests", records)
+	return records, nil
+}
@@ -2,11 +2,13 @@ package loyalty
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
 	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 	"go.temporal.io/sdk/worker"
 
@@ -21,6 +23,36 @@ type UnitTestSuite struct {
 	testsuite.WorkflowTestSuite
 }
 
+// updateCallbacks records the outcome of an update sent through the test environment.
+type updateCallbacks struct {
+	accepted bool
+	rejected error
+	result   interface{}
+	err      error
+}
+
+func (u *updateCallbacks) Accept() {
+	u.accepted = true
+}
+
+func (u *updateCallbacks) Reject(err error) {
+	u.rejected = err
+}
+
+func (u *updateCallbacks) Complete(success interface{}, err error) {
+	u.result = success
+	u.err = err
+}
+
+var statusLevels = DefaultProgram().StatusLevels
+
+// notified matches a SendNotification call for the email's template and parameters, whoever it was addressed to.
+func notified(email Email) interface{} {
+	return mock.MatchedBy(func(notification Notification) bool {
+		return notification.Template == email.Template && reflect.DeepEqual(notification.Params, email.Params)
+	})
+}
+
 func TestUnitTestSuite(t *testing.T) {
 	s := new(UnitTestSuite)
 	logger := NewZapAdapter(NewZapLogger(zapcore.WarnLevel))
@@ -77,13 +109,24 @@ func (s *UnitTestSuite) Test_AddPoints() {
 	s.NoError(env.GetWorkflowResult(nil))
 }
 
-func (s *UnitTestSuite) Test_AddPointsForSinglePromo() {
+func (s *UnitTestSuite) Test_AddPointsDuplicateTransaction() {
 	env := s.NewTestWorkflowEnvironment()
 	env.RegisterActivity(&Activities{})
 
+	transaction := PointsTransaction{
+		ID:     "txn-1",
+		Amount: 100,
+		Source: SourcePurchase,
+		Reason: "Order #1",
+	}
+
+	// the purchase pipeline retries the same signal; only the first should be credited
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalAddPoints, StatusLevels[1].MinimumPoints)
+		env.SignalWorkflow(SignalAddPoints, transaction)
 	}, 0)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, transaction)
+	}, time.Second*1)
 	env.RegisterDelayedCallback(func() {
 		result, err := env.QueryWorkflow(QueryGetStatus)
 		s.NoError(err)
@@ -91,12 +134,11 @@ func (s *UnitTestSuite) Test_AddPointsForSinglePromo() {
 		var state GetStatusResponse
 		err = result.Get(&state)
 		s.NoError(err)
-		s.Equal(StatusLevels[1].MinimumPoints, state.Points)
-		s.Equal(StatusLevels[1], &state.StatusLevel)
-	}, time.Second*1)
+		s.Equal(100, state.Points)
+	}, time.Second*2)
 	env.RegisterDelayedCallback(func() {
 		env.SignalWorkflow(SignalCancelAccount, nil)
-	}, time.Second*2)
+	}, time.Second*3)
 
 	customer := CustomerInfo{
 		AccountActive: true,
@@ -107,240 +149,1692 @@ func (s *UnitTestSuite) Test_AddPointsForSinglePromo() {
 	s.NoError(env.GetWorkflowResult(nil))
 }
 
-func (s *UnitTestSuite) Test_AddPointsForMultiPromo() {
+func (s *UnitTestSuite) Test_TransactionIDsNamespacedBySource() {
 	env := s.NewTestWorkflowEnvironment()
-	env.RegisterActivity(&Activities{})
 
-	targetLevel := len(StatusLevels) - 1
-	targetTier := StatusLevels[targetLevel]
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	reward := DefaultProgram().RewardForID("lounge-pass")
 
+	// a redemption that happens to share a purchase's ID isn't a duplicate of it
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalAddPoints, targetTier.MinimumPoints)
-	}, 0)
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "order-1", Amount: 100, Source: SourcePurchase})
+	}, time.Second*1)
+	redeemed := &updateCallbacks{}
 	env.RegisterDelayedCallback(func() {
-		result, err := env.QueryWorkflow(QueryGetStatus)
-		s.NoError(err)
+		env.UpdateWorkflow(UpdateRedeemReward, redeemed, RedeemRequest{ID: "order-1", RewardID: reward.ID})
+	}, time.Second*2)
 
-		var state GetStatusResponse
-		err = result.Get(&state)
-		s.NoError(err)
-		s.Equal(targetTier.MinimumPoints, state.Points)
-		s.Equal(targetTier, &state.StatusLevel)
-	}, time.Second*1)
+	// IDs recorded before namespacing are still recognized
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "legacy-1", Amount: 50, Source: SourceAdjustment})
+	}, time.Second*3)
 	env.RegisterDelayedCallback(func() {
 		env.SignalWorkflow(SignalCancelAccount, nil)
-	}, time.Second*2)
+	}, time.Second*4)
 
 	customer := CustomerInfo{
-		AccountActive: true,
+		LoyaltyPoints:        statusLevels[3].MinimumPoints,
+		AccountActive:        true,
+		RecentTransactionIDs: []string{"legacy-1"},
 	}
 	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
 	s.True(env.IsWorkflowCompleted())
 	s.NoError(env.GetWorkflowError())
-	s.NoError(env.GetWorkflowResult(nil))
+
+	s.NoError(redeemed.rejected)
+	s.NoError(redeemed.err)
+
+	var result CustomerInfo
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal([]string{"legacy-1", "purchase:order-1", "redeem:order-1"}, result.RecentTransactionIDs)
+	s.Len(result.PointsHistory, 1)
 }
 
-func (s *UnitTestSuite) Test_CancelAccount() {
+func (s *UnitTestSuite) Test_UpdateAddPoints() {
 	env := s.NewTestWorkflowEnvironment()
-
 	env.RegisterActivity(&Activities{})
-	env.OnActivity("SendEmail", mock.Anything, mock.Anything).Return(nil)
 
-	// cancel account
+	updated := &updateCallbacks{}
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalCancelAccount, nil)
+		env.UpdateWorkflow(UpdateAddPoints, updated, PointsTransaction{ID: "txn-1", Amount: statusLevels[1].MinimumPoints})
 	}, time.Second*1)
 
-	// check status
+	rejected := &updateCallbacks{}
 	env.RegisterDelayedCallback(func() {
-		result, err := env.QueryWorkflow(QueryGetStatus)
-		s.NoError(err)
-		var statusResponse GetStatusResponse
-		err = result.Get(&statusResponse)
-		s.NoError(err)
-		s.False(statusResponse.AccountActive)
+		env.UpdateWorkflow(UpdateAddPoints, rejected, PointsTransaction{ID: "txn-2", Amount: 0})
 	}, time.Second*2)
 
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
 	customer := CustomerInfo{
-		CustomerID:    "123",
 		AccountActive: true,
 	}
 	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
 	s.True(env.IsWorkflowCompleted())
 	s.NoError(env.GetWorkflowError())
-	s.NoError(env.GetWorkflowResult(nil))
 
-	env.AssertCalled(s.T(), "SendEmail", mock.Anything, emailCancelAccount)
+	s.True(updated.accepted)
+	s.NoError(updated.err)
+	status := updated.result.(GetStatusResponse)
+	s.Equal(statusLevels[1].MinimumPoints, status.Points)
+	s.Equal(*statusLevels[1], status.StatusLevel)
+
+	s.False(rejected.accepted)
+	s.Error(rejected.rejected)
 }
 
-func (s *UnitTestSuite) Test_InviteGuest() {
+func (s *UnitTestSuite) Test_UpdateRejectedForInactiveAccount() {
 	env := s.NewTestWorkflowEnvironment()
-	childEnv := s.NewTestWorkflowEnvironment()
 
 	a := &Activities{}
 	env.RegisterActivity(a)
-	childEnv.RegisterActivity(a)
 
-	env.OnActivity(a.SendEmail, mock.Anything, mock.Anything).
-		Return(nil)
-	childEnv.OnActivity(a.SendEmail, mock.Anything, mock.Anything).
+	// slow emails keep the workflow running after the account has been canceled
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		After(time.Second * 5).
 		Return(nil)
 
-	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
-		Run(func(args mock.Arguments) {
-			guest := args.Get(1).(CustomerInfo)
-			childEnv.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, true)
-		}).
-		Return(GuestInvited, nil)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*10)
 
+	rejected := &updateCallbacks{}
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalInviteGuest, "guest")
-	}, 0)
+		env.UpdateWorkflow(UpdateInviteGuest, rejected, "guest")
+	}, time.Second*12)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.False(rejected.accepted)
+	s.Error(rejected.rejected)
+}
+
+func (s *UnitTestSuite) Test_NonQualifyingPoints() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
 
+	bonus := statusLevels[len(statusLevels)-1].MinimumPoints
 	env.RegisterDelayedCallback(func() {
-		val, err := childEnv.QueryWorkflow(QueryGetStatus)
-		s.NoError(err)
-		var r GetStatusResponse
-		err = val.Get(&r)
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{
+			ID:            "goodwill-1",
+			Amount:        bonus,
+			Source:        SourceAdjustment,
+			NonQualifying: true,
+		})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
 		s.NoError(err)
-		s.True(r.AccountActive)
-	}, time.Second)
 
-	// cancel workflows to not timeout
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(statusLevels[1].MinimumPoints+bonus, state.Points)
+		s.Equal(statusLevels[1].MinimumPoints, state.QualifyingPoints)
+		s.Equal(*statusLevels[1], state.StatusLevel)
+	}, time.Second*2)
 	env.RegisterDelayedCallback(func() {
-		childEnv.SignalWorkflow(SignalCancelAccount, nil)
 		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(promotedEmail(statusLevels[len(statusLevels)-1].Name)))
+}
+
+func (s *UnitTestSuite) Test_PinnedProgram() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	program := &Program{
+		Version: 7,
+		StatusLevels: []*StatusLevel{
+			newStatusLevel(0, "Basic", 0, nil),
+			newStatusLevel(1, "Elite", 50, map[Benefit]int{BenefitGuests: 1}),
+		},
+		QualificationPeriod: time.Hour * 24 * 30,
+	}
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, 100)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(7, state.ProgramVersion)
+		s.Equal("Elite", state.StatusLevel.Name)
 	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
 
 	customer := CustomerInfo{
-		CustomerID:    "host",
-		LoyaltyPoints: StatusLevels[2].MinimumPoints,
+		Program:       program,
 		AccountActive: true,
 	}
 	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
 	s.True(env.IsWorkflowCompleted())
 	s.NoError(env.GetWorkflowError())
-	s.NoError(env.GetWorkflowResult(nil))
+}
 
-	env.AssertCalled(s.T(), "SendEmail", mock.Anything, emailGuestInvited)
-	env.AssertNotCalled(s.T(), "SendEmail", mock.Anything, emailInsufficientPoints)
+func (s *UnitTestSuite) Test_InvalidProgramFailsWorkflow() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
 
-	childEnv.AssertCalled(s.T(), "SendEmail", mock.Anything, fmt.Sprintf(emailWelcome, StatusLevels[1].Name))
+	customer := CustomerInfo{
+		Program:       &Program{Version: 1},
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.Error(env.GetWorkflowError())
 }
 
-func (s *UnitTestSuite) Test_QueryGuests() {
+func (s *UnitTestSuite) Test_EarningMultiplier() {
 	env := s.NewTestWorkflowEnvironment()
 
 	a := &Activities{}
 	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
 
-	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
-		Return(GuestInvited, nil)
-
-	guestID := "guest"
+	program, err := LoadProgram("program.yaml")
+	s.NoError(err)
+	gold := program.StatusLevels[3]
+	s.Equal(2.0, gold.EarningMultiplier)
 
-	// first, invite the guest. This should result in this guest's ID being added to the original customer
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalInviteGuest, guestID)
-	}, 0)
-
-	// Query the original customer's guest list
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "purchase-1", Amount: 100, Source: SourcePurchase})
+	}, time.Second*1)
 	env.RegisterDelayedCallback(func() {
-		result, err := env.QueryWorkflow(QueryGetGuests)
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "adjust-1", Amount: 100, Source: SourceAdjustment})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetPointsHistory)
 		s.NoError(err)
-		var guests []string
-		err = result.Get(&guests)
+
+		var history []PointsHistoryEntry
+		err = result.Get(&history)
 		s.NoError(err)
-		s.Equal(1, len(guests))
-		s.Equal(guestID, guests[0])
-	}, time.Second*1)
+		s.Len(history, 2)
+		s.Equal(2.0, history[0].Multiplier)
+		s.Equal(200, history[0].PointsCredited)
+		s.Equal(1.0, history[1].Multiplier)
+		s.Equal(100, history[1].PointsCredited)
 
-	// cancel workflows to not timeout
+		result, err = env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(gold.MinimumPoints+300, state.Points)
+	}, time.Second*3)
 	env.RegisterDelayedCallback(func() {
 		env.SignalWorkflow(SignalCancelAccount, nil)
-	}, time.Second*2)
+	}, time.Second*4)
 
 	customer := CustomerInfo{
-		LoyaltyPoints: StatusLevels[1].MinimumPoints,
+		Program:       program,
+		LoyaltyPoints: gold.MinimumPoints,
 		AccountActive: true,
 	}
 	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
 	s.True(env.IsWorkflowCompleted())
 	s.NoError(env.GetWorkflowError())
-	s.NoError(env.GetWorkflowResult(nil))
 }
 
-func (s *UnitTestSuite) Test_InviteGuestPreviouslyCanceled() {
+func (s *UnitTestSuite) Test_PurchaseRefund() {
 	env := s.NewTestWorkflowEnvironment()
 
 	a := &Activities{}
 	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
 
-	env.OnActivity(a.SendEmail, mock.Anything, mock.Anything).
-		Return(nil)
+	program, err := LoadProgram("program.yaml")
+	s.NoError(err)
+	gold := program.StatusLevels[3]
 
-	call := 0
-	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
-		Twice().
-		Return(func(_ context.Context, _ CustomerInfo) (GuestInviteResult, error) {
-			if call == 0 {
-				call++
-				return GuestInvited, nil
-			}
-			return GuestAlreadyCanceled, nil
+	env.RegisterDelayedCallback(func() {
+		now := env.Now()
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "double-weekend", Start: now, End: now.Add(time.Hour), Multiplier: 2,
 		})
+		env.SignalWorkflow(SignalPurchase, Purchase{ID: "order-1", Amount: 100, Currency: "USD"})
+	}, time.Second*1)
 
-	order := time.Second
-	guestID := "guest"
+	// refunded after the campaign ended, and against a purchase the customer no longer has
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalPurchase, Purchase{ID: "refund-1", Amount: -100, Currency: "USD", RefundOf: "order-1"})
+		env.SignalWorkflow(SignalPurchase, Purchase{ID: "refund-2", Amount: -100, Currency: "USD", RefundOf: "order-0"})
+	}, time.Hour*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetPointsHistory)
+		s.NoError(err)
 
-	// first, invite the guest. This should result in another workflow being started
+		var history []PointsHistoryEntry
+		err = result.Get(&history)
+		s.NoError(err)
+		s.Len(history, 3)
+		s.Equal(400, history[0].PointsCredited)
+
+		// the refund takes back exactly what the purchase earned
+		s.Equal(2.0, history[1].Multiplier)
+		s.Equal("double-weekend", history[1].CampaignID)
+		s.Equal(2.0, history[1].CampaignMultiplier)
+		s.Equal(-400, history[1].PointsCredited)
+
+		// without the original, the current multipliers apply
+		s.Empty(history[2].CampaignID)
+		s.Equal(-200, history[2].PointsCredited)
+	}, time.Hour*2+time.Second)
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalInviteGuest, guestID)
-	}, order)
-	order += time.Second
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*2+time.Second*2)
 
-	// then, try to invite them again. the "guest has already canceled" email should be sent
+	customer := CustomerInfo{
+		Program:       program,
+		LoyaltyPoints: gold.MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *UnitTestSuite) Test_PurchaseRefundValidation() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	notRefund := &updateCallbacks{}
 	env.RegisterDelayedCallback(func() {
-		env.SignalWorkflow(SignalInviteGuest, guestID)
-	}, order)
-	order += time.Second
+		env.UpdateWorkflow(UpdatePurchase, notRefund, Purchase{ID: "order-2", Amount: -10, Currency: "USD"})
+	}, time.Second*1)
 
-	// cancel original workflow to not timeout
+	// the refund is worth more points than the customer has left
+	overdrawn := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdatePurchase, overdrawn,
+			Purchase{ID: "refund-1", Amount: -100, Currency: "USD", RefundOf: "order-1"})
+	}, time.Second*2)
 	env.RegisterDelayedCallback(func() {
 		env.SignalWorkflow(SignalCancelAccount, nil)
-	}, order)
-	order += time.Second
+	}, time.Second*3)
 
 	customer := CustomerInfo{
-		LoyaltyPoints: StatusLevels[2].MinimumPoints,
+		LoyaltyPoints: 50,
 		AccountActive: true,
 	}
 	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
 	s.True(env.IsWorkflowCompleted())
 	s.NoError(env.GetWorkflowError())
-	s.NoError(env.GetWorkflowResult(nil))
 
-	env.AssertCalled(s.T(), "SendEmail", mock.Anything, emailGuestInvited)
-	env.AssertCalled(s.T(), "SendEmail", mock.Anything, emailGuestCanceled)
+	s.ErrorContains(notRefund.rejected, "isn't a refund")
+	s.NoError(overdrawn.rejected)
+	s.ErrorContains(overdrawn.err, "would leave a negative balance")
+
+	var result CustomerInfo
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal(50, result.LoyaltyPoints)
 }
 
-func (s *UnitTestSuite) Test_SendEmailActivity() {
-	env := s.NewTestActivityEnvironment()
+func (s *UnitTestSuite) Test_Purchase() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
 
-	var a *Activities
-	env.RegisterActivity(a)
+	purchase := Purchase{ID: "order-1", Amount: 99.95, Currency: "USD", Category: "electronics"}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalPurchase, purchase)
+	}, time.Second*1)
 
-	_, err := env.ExecuteActivity(a.SendEmail, "Hello, World!")
-	s.NoError(err)
+	// a retried purchase is only credited once
+	updated := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdatePurchase, updated, purchase)
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.NoError(updated.err)
+	s.Equal(99, updated.result.(GetStatusResponse).Points)
 }
 
-func (s *UnitTestSuite) Test_SimpleReplay() {
-	replayer, err := worker.NewWorkflowReplayerWithOptions(worker.WorkflowReplayerOptions{
-		DataConverter: converter.GetDefaultDataConverter(),
-	})
-	s.NoError(err)
+func (s *UnitTestSuite) Test_Campaign() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
 
-	replayer.RegisterWorkflow(CustomerLoyaltyWorkflow)
-	err = replayer.ReplayWorkflowHistoryFromJSONFile(nil, "simple_replay.json")
+	env.RegisterDelayedCallback(func() {
+		now := env.Now()
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "double-weekend", Start: now, End: now.Add(time.Hour), Multiplier: 2,
+		})
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "vip-triple", Start: now, End: now.Add(time.Hour), Multiplier: 3, Segments: []string{"vip"},
+		})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "purchase-1", Amount: 100, Source: SourcePurchase})
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "adjust-1", Amount: 100, Source: SourceAdjustment})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "purchase-2", Amount: 100, Source: SourcePurchase})
+	}, time.Hour*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetPointsHistory)
+		s.NoError(err)
+
+		var history []PointsHistoryEntry
+		err = result.Get(&history)
+		s.NoError(err)
+		s.Len(history, 3)
+
+		// the customer isn't in the vip segment, so only the 2x campaign applies
+		s.Equal("double-weekend", history[0].CampaignID)
+		s.Equal(2.0, history[0].CampaignMultiplier)
+		s.Equal(200, history[0].PointsCredited)
+
+		// manual adjustments and purchases after the window aren't multiplied
+		s.Empty(history[1].CampaignID)
+		s.Equal(100, history[1].PointsCredited)
+		s.Empty(history[2].CampaignID)
+		s.Equal(100, history[2].PointsCredited)
+	}, time.Hour*2+time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*2+time.Second*2)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *UnitTestSuite) Test_CampaignForSegment() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	env.RegisterDelayedCallback(func() {
+		now := env.Now()
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "double-weekend", Start: now, End: now.Add(time.Hour), Multiplier: 2,
+		})
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "vip-triple", Start: now, End: now.Add(time.Hour), Multiplier: 3, Segments: []string{"vip"},
+		})
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "platinum-only", Start: now, End: now.Add(time.Hour), Multiplier: 5,
+			EligibleStatuses: []string{statusLevels[len(statusLevels)-1].Name},
+		})
+	}, time.Second*1)
+
+	updated := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateAddPoints, updated,
+			PointsTransaction{ID: "purchase-1", Amount: 100, Source: SourcePurchase})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+		Segments:      []string{"vip"},
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.NoError(updated.err)
+	s.Equal(300, updated.result.(GetStatusResponse).Points)
+}
+
+func (s *UnitTestSuite) Test_CampaignForStatusAcrossPrograms() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	// the customer's second level is "Elite", not the default program's "Bronze"
+	env.RegisterDelayedCallback(func() {
+		now := env.Now()
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "bronze-only", Start: now, End: now.Add(time.Hour), Multiplier: 5,
+			EligibleStatuses: []string{statusLevels[1].Name},
+		})
+		env.SignalWorkflow(SignalAddCampaign, Campaign{
+			ID: "elite-only", Start: now, End: now.Add(time.Hour), Multiplier: 3,
+			EligibleStatuses: []string{"Elite"},
+		})
+	}, time.Second*1)
+
+	updated := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateAddPoints, updated,
+			PointsTransaction{ID: "purchase-1", Amount: 100, Source: SourcePurchase})
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		Program: &Program{
+			Version: 7,
+			StatusLevels: []*StatusLevel{
+				newStatusLevel(0, "Basic", 0, nil),
+				newStatusLevel(1, "Elite", 50, nil),
+			},
+			QualificationPeriod: time.Hour * 24 * 30,
+		},
+		LoyaltyPoints: 50,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.NoError(updated.err)
+	s.Equal(350, updated.result.(GetStatusResponse).Points)
+}
+
+func (s *UnitTestSuite) Test_AddPointsForSinglePromo() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[1].MinimumPoints)
+	}, 0)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(statusLevels[1].MinimumPoints, state.Points)
+		s.Equal(statusLevels[1], &state.StatusLevel)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+}
+
+func (s *UnitTestSuite) Test_AddPointsForMultiPromo() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	targetLevel := len(statusLevels) - 1
+	targetTier := statusLevels[targetLevel]
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, targetTier.MinimumPoints)
+	}, 0)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(targetTier.MinimumPoints, state.Points)
+		s.Equal(targetTier, &state.StatusLevel)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+}
+
+func (s *UnitTestSuite) Test_RequalificationSoftLanding() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	platinum := statusLevels[4]
+	gold := statusLevels[3]
+
+	queryStatus := func() GetStatusResponse {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		return state
+	}
+
+	// losing qualifying points mid-period doesn't demote
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "clawback", Amount: -platinum.MinimumPoints})
+	}, time.Hour)
+	env.RegisterDelayedCallback(func() {
+		state := queryStatus()
+		s.Equal(0, state.QualifyingPoints)
+		s.Equal(*platinum, state.StatusLevel)
+	}, time.Hour*2)
+
+	// at the anniversary, the customer qualified for nothing but only drops one level
+	env.RegisterDelayedCallback(func() {
+		s.Equal(*gold, queryStatus().StatusLevel)
+	}, DefaultProgram().QualificationPeriod+time.Hour)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, DefaultProgram().QualificationPeriod+time.Hour*2)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: platinum.MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(demotedEmail(gold.Name)))
+}
+
+func (s *UnitTestSuite) Test_RequalificationKeepsSponsoredStatus() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(nil)
+
+	silver := statusLevels[2]
+	queryStatus := func() GetStatusResponse {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		s.NoError(result.Get(&state))
+		return state
+	}
+
+	// the guest earned nothing themselves, but their host still sponsors them
+	env.RegisterDelayedCallback(func() {
+		s.Equal(*silver, queryStatus().StatusLevel)
+		env.SignalWorkflow(SignalRevokeSponsorship, "host")
+	}, DefaultProgram().QualificationPeriod+time.Hour)
+	env.RegisterDelayedCallback(func() {
+		s.Equal(*statusLevels[0], queryStatus().StatusLevel)
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, DefaultProgram().QualificationPeriod+time.Hour*2)
+
+	guest := CustomerInfo{
+		CustomerID:       "guest",
+		AccountActive:    true,
+		StatusOrdinal:    silver.Ordinal,
+		SponsorID:        "host",
+		SponsoredOrdinal: silver.Ordinal,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(statusRetainedEmail(silver.Name)))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(demotedEmail(statusLevels[1].Name)))
+}
+
+func (s *UnitTestSuite) Test_RequalificationRetainsStatus() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	silver := statusLevels[2]
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-1", Amount: silver.MinimumPoints})
+	}, time.Hour)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(*silver, state.StatusLevel)
+		s.Equal(0, state.QualifyingPoints)
+	}, DefaultProgram().QualificationPeriod+time.Hour)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, DefaultProgram().QualificationPeriod+time.Hour*2)
+
+	customer := CustomerInfo{
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(statusRetainedEmail(silver.Name)))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(demotedEmail(statusLevels[1].Name)))
+}
+
+func (s *UnitTestSuite) Test_UseBenefit() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	program, err := LoadProgram("program.yaml")
+	s.NoError(err)
+	silver := program.StatusLevels[2]
+	s.Equal(1, silver.Benefits[BenefitLoungePasses])
+
+	used := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateUseBenefit, used, UseBenefitRequest{ID: "lounge-1", Benefit: BenefitLoungePasses})
+	}, time.Second*1)
+
+	exhausted := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateUseBenefit, exhausted, UseBenefitRequest{ID: "lounge-2", Benefit: BenefitLoungePasses})
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetEntitlements)
+		s.NoError(err)
+
+		var entitlements []Entitlement
+		err = result.Get(&entitlements)
+		s.NoError(err)
+		s.Equal([]Entitlement{
+			{Benefit: BenefitGuests, Allowed: 2, Used: 0, Remaining: 2},
+			{Benefit: BenefitLoungePasses, Allowed: 1, Used: 1, Remaining: 0},
+			{Benefit: BenefitPriorityLines, Allowed: 6, Used: 0, Remaining: 6},
+		}, entitlements)
+	}, time.Second*3)
+
+	// benefits are granted anew at requalification
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetEntitlements)
+		s.NoError(err)
+
+		var entitlements []Entitlement
+		err = result.Get(&entitlements)
+		s.NoError(err)
+		s.Contains(entitlements, Entitlement{Benefit: BenefitLoungePasses, Allowed: 1, Used: 0, Remaining: 1})
+	}, program.QualificationPeriod+time.Hour)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, program.QualificationPeriod+time.Hour*2)
+
+	customer := CustomerInfo{
+		Program:       program,
+		LoyaltyPoints: silver.MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.NoError(used.err)
+	s.Error(exhausted.rejected)
+}
+
+func (s *UnitTestSuite) Test_CancelAccount() {
+	env := s.NewTestWorkflowEnvironment()
+
+	env.RegisterActivity(&Activities{})
+	env.OnActivity("SendNotification", mock.Anything, mock.Anything).Return(nil)
+
+	// cancel account
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*1)
+
+	// check status
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var statusResponse GetStatusResponse
+		err = result.Get(&statusResponse)
+		s.NoError(err)
+		s.False(statusResponse.AccountActive)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		CustomerID:    "123",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(cancelAccountEmail()))
+}
+
+func (s *UnitTestSuite) Test_InviteGuest() {
+	env := s.NewTestWorkflowEnvironment()
+	childEnv := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	childEnv.RegisterActivity(a)
+
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Return(nil)
+	childEnv.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Return(nil)
+	// the guest keeps its host up to date on its status
+	childEnv.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(nil)
+
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			guest := args.Get(1).(CustomerInfo)
+			childEnv.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, true)
+		}).
+		Return(GuestInvited, nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, "guest")
+	}, 0)
+
+	env.RegisterDelayedCallback(func() {
+		val, err := childEnv.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+		var r GetStatusResponse
+		err = val.Get(&r)
+		s.NoError(err)
+		s.True(r.AccountActive)
+	}, time.Second)
+
+	// cancel workflows to not timeout
+	env.RegisterDelayedCallback(func() {
+		childEnv.SignalWorkflow(SignalCancelAccount, nil)
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(insufficientPointsEmail()))
+
+	childEnv.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(welcomeEmail(statusLevels[1].Name)))
+}
+
+func (s *UnitTestSuite) Test_RevokeGuest() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("guest"), "", SignalRevokeSponsorship, "host").
+		Return(nil).Once()
+
+	rejected := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRevokeGuest, rejected, "stranger")
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalRevokeGuest, "guest")
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+
+		var guests []string
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Empty(guests)
+	}, time.Second*3)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*4)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		Guests:        []string{"guest"},
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(rejected.rejected, "'stranger' is not a guest")
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestRevokedEmail("guest")))
+	env.AssertExpectations(s.T())
+}
+
+func (s *UnitTestSuite) Test_RevokeSponsorship() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalRevokeSponsorship, "someone-else")
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(statusLevels[1].Name, state.StatusLevel.Name)
+
+		env.SignalWorkflow(SignalRevokeSponsorship, "host")
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(statusLevels[0].Name, state.StatusLevel.Name)
+	}, time.Second*3)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*4)
+
+	guest := CustomerInfo{
+		CustomerID:    "guest",
+		StatusOrdinal: statusLevels[1].Ordinal,
+		SponsorID:     "host",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(sponsorshipRevokedEmail(statusLevels[0].Name)))
+}
+
+func (s *UnitTestSuite) Test_CancelAccountRevokesGuests() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("guest-1"), "", SignalRevokeSponsorship, "host").
+		Return(nil).Once()
+	// a guest that has already closed their account doesn't stop the cancellation
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("guest-2"), "", SignalRevokeSponsorship, "host").
+		Return(errors.New("workflow not found")).Once()
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, CancelAccountRequest{RevokeGuests: true})
+	}, time.Second*1)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[3].MinimumPoints,
+		Guests:        []string{"guest-1", "guest-2"},
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(cancelAccountEmail()))
+	env.AssertExpectations(s.T())
+}
+
+func (s *UnitTestSuite) Test_GuestInvitationAccepted() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	var code string
+	var hostNotifications []Notification
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			notification := args.Get(1).(Notification)
+			if notification.Template == TemplateGuestInvitation {
+				// only the guest ever sees the code
+				s.Equal("guest", notification.CustomerID)
+				s.Equal("guest@example.com", notification.Contact.Email)
+				code = notification.Params.Code
+			} else if notification.CustomerID == "host" {
+				hostNotifications = append(hostNotifications, notification)
+			}
+		}).
+		Return(nil)
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).Return(GuestInvited, nil).Once()
+
+	program := DefaultProgram()
+	program.GuestInvitationTTL = time.Hour * 24
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, InviteGuestRequest{
+			GuestID: "guest",
+			Contact: ContactProfile{Email: "guest@example.com"},
+		})
+	}, time.Second*1)
+
+	wrongCode := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		s.NotEmpty(code)
+		env.AssertNotCalled(s.T(), "StartGuestWorkflow", mock.Anything, mock.Anything)
+
+		result, err := env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		err = result.Get(&invitations)
+		s.NoError(err)
+		s.Len(invitations, 1)
+		s.Equal(InvitationPending, invitations[0].State)
+		s.Empty(invitations[0].Code)
+
+		env.UpdateWorkflow(UpdateAcceptInvitation, wrongCode, AcceptInvitationRequest{GuestID: "guest", Code: "nope"})
+	}, time.Second*2)
+
+	accepted := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateAcceptInvitation, accepted, AcceptInvitationRequest{GuestID: "guest", Code: code})
+	}, time.Second*3)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		err = result.Get(&invitations)
+		s.NoError(err)
+		s.Len(invitations, 1)
+		s.Equal(InvitationAccepted, invitations[0].State)
+	}, time.Second*4)
+
+	// the accepted guest keeps their slot past the deadline
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+
+		var guests []string
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Equal([]string{"guest"}, guests)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*48)
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(wrongCode.rejected, "invalid invitation code")
+	s.NoError(accepted.err)
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+
+	// the host is told the invitation went out, without the code
+	sent := false
+	for _, notification := range hostNotifications {
+		s.Empty(notification.Params.Code)
+		if notification.Template == TemplateInvitationSent {
+			s.Equal("guest", notification.Params.GuestID)
+			sent = true
+		}
+	}
+	s.True(sent)
+}
+
+func (s *UnitTestSuite) Test_GuestInvitationExpires() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	program := DefaultProgram()
+	program.GuestInvitationTTL = time.Hour * 24
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, InviteGuestRequest{
+			GuestID: "guest",
+			Contact: ContactProfile{Email: "guest@example.com"},
+		})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetEntitlements)
+		s.NoError(err)
+
+		var entitlements []Entitlement
+		err = result.Get(&entitlements)
+		s.NoError(err)
+		s.Equal(Entitlement{Benefit: BenefitGuests, Allowed: 1, Used: 1, Remaining: 0}, entitlements[0])
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+
+		var guests []string
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Empty(guests)
+
+		result, err = env.QueryWorkflow(QueryGetInvitations)
+		s.NoError(err)
+
+		var invitations []GuestInvitation
+		err = result.Get(&invitations)
+		s.NoError(err)
+		s.Empty(invitations)
+
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*25)
+
+	customer := CustomerInfo{
+		Program:       program,
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(invitationExpiredEmail("guest")))
+}
+
+func (s *UnitTestSuite) Test_QueryGuests() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Return(GuestInvited, nil)
+
+	guestID := "guest"
+
+	// first, invite the guest. This should result in this guest's ID being added to the original customer
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, guestID)
+	}, 0)
+
+	// Query the original customer's guest list
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetGuests)
+		s.NoError(err)
+		var guests []string
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Equal(1, len(guests))
+		s.Equal(guestID, guests[0])
+	}, time.Second*1)
+
+	// cancel workflows to not timeout
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*2)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: statusLevels[1].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+}
+
+func (s *UnitTestSuite) Test_QueryGuestDetails() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalGuestStatus, GuestStatus{
+			GuestID: "guest", StatusOrdinal: 1, StatusLevel: statusLevels[1].Name, AccountActive: true, UpdatedAt: env.Now(),
+		})
+		env.SignalWorkflow(SignalGuestStatus, GuestStatus{GuestID: "stranger", AccountActive: true})
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetGuestDetails)
+		s.NoError(err)
+
+		var guests []GuestRecord
+		err = result.Get(&guests)
+		s.NoError(err)
+		s.Len(guests, 2)
+
+		s.Equal("guest", guests[0].GuestID)
+		s.Equal(InvitationAccepted, guests[0].State)
+		s.Equal(statusLevels[1].Name, guests[0].StatusLevel)
+		s.True(guests[0].AccountActive)
+		s.False(guests[0].StatusUpdatedAt.IsZero())
+
+		// no report yet
+		s.Equal("quiet-guest", guests[1].GuestID)
+		s.Empty(guests[1].StatusLevel)
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		CustomerID:    "host",
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		Guests:        []string{"guest", "quiet-guest"},
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *UnitTestSuite) Test_GuestReportsStatusToSponsor() {
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&Activities{})
+
+	reportedStatus := func(level string, active bool) interface{} {
+		return mock.MatchedBy(func(status GuestStatus) bool {
+			return status.GuestID == "guest" && status.StatusLevel == level && status.AccountActive == active
+		})
+	}
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus,
+		reportedStatus(statusLevels[1].Name, true)).Return(nil).Once()
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus,
+		reportedStatus(statusLevels[2].Name, true)).Return(nil).Once()
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus,
+		reportedStatus(statusLevels[2].Name, false)).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		// doesn't change status, so isn't reported
+		env.SignalWorkflow(SignalAddPoints, 10)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[2].MinimumPoints)
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	guest := CustomerInfo{
+		CustomerID:    "guest",
+		StatusOrdinal: statusLevels[1].Ordinal,
+		SponsorID:     "host",
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	env.AssertExpectations(s.T())
+}
+
+func (s *UnitTestSuite) Test_GuestStopsReportingToClosedSponsor() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	// the host's account closed, so their workflow is gone
+	env.OnSignalExternalWorkflow(mock.Anything, CustomerWorkflowID("host"), "", SignalGuestStatus, mock.Anything).
+		Return(&temporal.UnknownExternalWorkflowExecutionError{}).Once()
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, 10)
+	}, time.Second*1)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, statusLevels[2].MinimumPoints)
+	}, time.Second*2)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	guest := CustomerInfo{
+		CustomerID:       "guest",
+		StatusOrdinal:    statusLevels[1].Ordinal,
+		SponsorID:        "host",
+		SponsoredOrdinal: statusLevels[1].Ordinal,
+		AccountActive:    true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, guest, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// tried once, then gave up on the sponsor instead of signaling it every iteration
+	env.AssertExpectations(s.T())
+	var final CustomerInfo
+	s.NoError(env.GetWorkflowResult(&final))
+	s.Empty(final.SponsorID)
+	s.Zero(final.SponsoredOrdinal)
+	s.Equal(statusLevels[2].Ordinal, final.StatusOrdinal)
+}
+
+func (s *UnitTestSuite) Test_InviteGuestPreviouslyCanceled() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).
+		Return(nil)
+
+	call := 0
+	env.OnActivity(a.StartGuestWorkflow, mock.Anything, mock.Anything).
+		Twice().
+		Return(func(_ context.Context, _ CustomerInfo) (GuestInviteResult, error) {
+			if call == 0 {
+				call++
+				return GuestInvited, nil
+			}
+			return GuestAlreadyCanceled, nil
+		})
+
+	order := time.Second
+	guestID := "guest"
+
+	// first, invite the guest. This should result in another workflow being started
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, guestID)
+	}, order)
+	order += time.Second
+
+	// then, try to invite them again. the "guest has already canceled" email should be sent
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalInviteGuest, guestID)
+	}, order)
+	order += time.Second
+
+	// cancel original workflow to not timeout
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, order)
+	order += time.Second
+
+	customer := CustomerInfo{
+		LoyaltyPoints: statusLevels[2].MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.NoError(env.GetWorkflowResult(nil))
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestInvitedEmail()))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(guestCanceledEmail()))
+}
+
+func (s *UnitTestSuite) Test_RedeemReward() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	reward := DefaultProgram().RewardForID("lounge-pass")
+	startingTier := statusLevels[3]
+
+	redeemed := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, redeemed, RedeemRequest{ID: "redeem-1", RewardID: reward.ID})
+	}, time.Second*1)
+
+	tooExpensive := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, tooExpensive, RedeemRequest{ID: "redeem-2", RewardID: "upgrade"})
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: startingTier.MinimumPoints,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// spending points reduces the balance, but not the customer's status
+	s.NoError(redeemed.err)
+	status := redeemed.result.(GetStatusResponse)
+	s.Equal(startingTier.MinimumPoints-reward.Cost, status.Points)
+	s.Equal(*startingTier, status.StatusLevel)
+
+	s.Error(tooExpensive.rejected)
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(rewardRedeemedEmail(reward.Name, reward.Cost)))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(demotedEmail(statusLevels[2].Name)))
+}
+
+func (s *UnitTestSuite) Test_RedeemRewardFulfillmentFails() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.FulfillReward, mock.Anything, mock.Anything, mock.Anything).
+		Return(temporal.NewNonRetryableApplicationError("out of stock", "OutOfStock", nil))
+
+	reward := DefaultProgram().RewardForID("coffee")
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalRedeemReward, RedeemRequest{RewardID: reward.ID})
+	}, time.Second*1)
+
+	// points should have been refunded
+	env.RegisterDelayedCallback(func() {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		s.Equal(reward.Cost, state.Points)
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*3)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: reward.Cost,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(redemptionFailedEmail(reward.Name, reward.Cost)))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(rewardRedeemedEmail(reward.Name, reward.Cost)))
+}
+
+func (s *UnitTestSuite) Test_RedeemRewardRetried() {
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.FulfillReward, mock.Anything, mock.Anything, mock.MatchedBy(func(r Redemption) bool {
+		return r.ID == "redeem-fails"
+	})).Return(temporal.NewNonRetryableApplicationError("out of stock", "OutOfStock", nil))
+	env.OnActivity(a.FulfillReward, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	reward := DefaultProgram().RewardForID("coffee")
+
+	// a failed redemption is refunded, and retrying it gets the same failure
+	failed := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, failed, RedeemRequest{ID: "redeem-fails", RewardID: reward.ID})
+	}, time.Second*1)
+	failedRetry := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, failedRetry, RedeemRequest{ID: "redeem-fails", RewardID: reward.ID})
+	}, time.Second*2)
+
+	// retrying a redemption that succeeded isn't rejected for the balance it left
+	redeemed := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, redeemed, RedeemRequest{ID: "redeem-1", RewardID: reward.ID})
+	}, time.Second*3)
+	redeemedRetry := &updateCallbacks{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(UpdateRedeemReward, redeemedRetry, RedeemRequest{ID: "redeem-1", RewardID: reward.ID})
+	}, time.Second*4)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Second*5)
+
+	customer := CustomerInfo{
+		LoyaltyPoints: reward.Cost,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	s.ErrorContains(failed.err, "could not fulfill reward")
+	s.NoError(failedRetry.rejected)
+	s.ErrorContains(failedRetry.err, "could not fulfill reward")
+
+	s.NoError(redeemed.err)
+	s.NoError(redeemedRetry.rejected)
+	s.NoError(redeemedRetry.err)
+	s.Equal(0, redeemedRetry.result.(GetStatusResponse).Points)
+	env.AssertNumberOfCalls(s.T(), "FulfillReward", 2)
+}
+
+func (s *UnitTestSuite) Test_PointsExpiration() {
+	program := DefaultProgram()
+	program.PointsExpiration = ExpirationPolicy{
+		BatchLifetime: time.Hour * 24 * 30,
+		WarningLead:   time.Hour * 24 * 7,
+	}
+
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	start := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-1", Amount: 100})
+	}, time.Hour)
+
+	queryPoints := func() int {
+		result, err := env.QueryWorkflow(QueryGetStatus)
+		s.NoError(err)
+
+		var state GetStatusResponse
+		err = result.Get(&state)
+		s.NoError(err)
+		return state.Points
+	}
+	env.RegisterDelayedCallback(func() {
+		s.Equal(100, queryPoints())
+	}, time.Hour*24*29)
+	env.RegisterDelayedCallback(func() {
+		s.Equal(0, queryPoints())
+	}, time.Hour*24*31)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*24*32)
+
+	customer := CustomerInfo{
+		Program:       program,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	expiresAt := start.Add(time.Hour).Add(program.PointsExpiration.BatchLifetime)
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(pointsExpiringEmail(100, expiresAt)))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(pointsExpiredEmail(100)))
+}
+
+func (s *UnitTestSuite) Test_PointsExpirationWarnsAgainAfterEarning() {
+	program := DefaultProgram()
+	program.PointsExpiration = ExpirationPolicy{
+		InactivityPeriod: time.Hour * 24 * 30,
+		WarningLead:      time.Hour * 24 * 7,
+	}
+
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	start := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-1", Amount: 100})
+	}, time.Hour)
+	// after the first warning, earning again pushes the expiry back
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalAddPoints, PointsTransaction{ID: "txn-2", Amount: 50})
+	}, time.Hour*24*25)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*24*50)
+
+	customer := CustomerInfo{
+		Program:       program,
+		AccountActive: true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, true)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// warned about the first expiry, then again about the new one, covering both batches
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything,
+		notified(pointsExpiringEmail(100, start.Add(time.Hour).Add(time.Hour*24*30))))
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything,
+		notified(pointsExpiringEmail(150, start.Add(time.Hour*24*55))))
+	env.AssertNotCalled(s.T(), "SendNotification", mock.Anything, notified(pointsExpiredEmail(100)))
+}
+
+func (s *UnitTestSuite) Test_PointsExpirationAfterContinueAsNew() {
+	program := DefaultProgram()
+	program.PointsExpiration = ExpirationPolicy{
+		InactivityPeriod: time.Hour * 24 * 90,
+	}
+
+	env := s.NewTestWorkflowEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.SendNotification, mock.Anything, mock.Anything).Return(nil)
+
+	start := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	env.SetStartTime(start)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(SignalCancelAccount, nil)
+	}, time.Hour*24*31)
+
+	// carried over from a previous run, last active 60 days before this one started
+	lastEarned := start.Add(-time.Hour * 24 * 60)
+	customer := CustomerInfo{
+		Program:                  program,
+		LoyaltyPoints:            300,
+		QualifyingPoints:         300,
+		QualificationPeriodStart: lastEarned,
+		PointBatches:             []PointsBatch{{Points: 300, EarnedAt: lastEarned}},
+		LastEarnedAt:             lastEarned,
+		AccountActive:            true,
+	}
+	env.ExecuteWorkflow(CustomerLoyaltyWorkflow, customer, false)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	env.AssertCalled(s.T(), "SendNotification", mock.Anything, notified(pointsExpiredEmail(300)))
+}
+
+func (s *UnitTestSuite) Test_SendEmailActivity() {
+	env := s.NewTestActivityEnvironment()
+
+	a := &Activities{}
+	env.RegisterActivity(a)
+
+	_, err := env.ExecuteActivity(a.SendEmail, Email{Body: "Hello, World!"})
+	s.NoError(err)
+
+	_, err = env.ExecuteActivity(a.SendEmail, welcomeEmail("Member"))
+	s.NoError(err)
+
+	_, err = env.ExecuteActivity(a.SendEmail, Email{Template: "noSuchTemplate"})
+	var appErr *temporal.ApplicationError
+	s.ErrorAs(err, &appErr)
+	s.Equal(ErrTypeInvalidEmail, appErr.Type())
+	s.True(appErr.NonRetryable())
+}
+
+func (s *UnitTestSuite) Test_SimpleReplay() {
+	replayer, err := worker.NewWorkflowReplayerWithOptions(worker.WorkflowReplayerOptions{
+		DataConverter: converter.GetDefaultDataConverter(),
+	})
+	s.NoError(err)
+
+	replayer.RegisterWorkflow(CustomerLoyaltyWorkflow)
+	err = replayer.ReplayWorkflowHistoryFromJSONFile(nil, "simple_replay.json")
+	s.NoError(err)
+}
+
+// Test_DemotionReplay replays a run from before annual requalification, which demoted as soon as points were taken
+// away.
+func (s *UnitTestSuite) Test_DemotionReplay() {
+	replayer, err := worker.NewWorkflowReplayerWithOptions(worker.WorkflowReplayerOptions{
+		DataConverter: converter.GetDefaultDataConverter(),
+	})
+	s.NoError(err)
+
+	replayer.RegisterWorkflow(CustomerLoyaltyWorkflow)
+	err = replayer.ReplayWorkflowHistoryFromJSONFile(nil, "demotion_replay.json")
 	s.NoError(err)
 }